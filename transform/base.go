@@ -0,0 +1,163 @@
+package transform
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/jimrobinson/xml/xmlbase"
+	"github.com/jimrobinson/xml/xmlns"
+	"github.com/jimrobinson/xml/xmlpath"
+)
+
+// BaseHandler is an IdentityTransform that tracks the path, the
+// namespace mappings and the xml:base of the current element.  Embed
+// a *BaseHandler in a handler, and pass the handler to Transform:
+//
+//	type Handler struct {
+//		*transform.BaseHandler
+//	}
+//
+//	func (h *Handler) StartElement(node xml.StartElement) error {
+//		... h.Path(), h.Base() and h.Namespaces() include node ...
+//		return h.BaseHandler.StartElement(node)
+//	}
+//
+//	err = transform.Transform(r, h)
+//
+// Each element is pushed before the handler's StartElement is called,
+// and popped after its EndElement returns, so both see the element as
+// the current one.  Transform does so by passing the handler through
+// Track, which sets Raw from TransformOptions.RawTokens.  A handler
+// used as the sink of a Pipeline must be passed through Track by the
+// caller.
+type BaseHandler struct {
+	*IdentityTransform
+	path *xmlpath.XmlPath
+	base *xmlbase.XmlBase
+}
+
+// NewBaseHandler returns a BaseHandler writing to w, resolving
+// relative references against baseURI outside of any xml:base.
+func NewBaseHandler(w io.Writer, baseURI string) (*BaseHandler, error) {
+	base, err := xmlbase.NewXmlBase(baseURI)
+	if err != nil {
+		return nil, err
+	}
+	return &BaseHandler{
+		IdentityTransform: NewIdentityTransform(w),
+		path:              xmlpath.NewXmlPath(),
+		base:              base,
+	}, nil
+}
+
+// Path returns the path of the current element
+func (b *BaseHandler) Path() *xmlpath.XmlPath {
+	return b.path
+}
+
+// Base returns the xml:base in effect for the current element
+func (b *BaseHandler) Base() *xmlbase.XmlBase {
+	return b.base
+}
+
+// Namespaces returns the namespace mappings in scope for the current
+// element
+func (b *BaseHandler) Namespaces() *xmlns.XmlNamespace {
+	return b.path.Namespaces()
+}
+
+func (b *BaseHandler) baseHandler() *BaseHandler {
+	return b
+}
+
+// push records the start of node.  An xml:base that cannot be parsed
+// is ignored, and its error returned.
+func (b *BaseHandler) push(node xml.StartElement) error {
+	if b.Raw {
+		b.path.PushRaw(node)
+	} else {
+		b.path.Push(node)
+	}
+	err := b.base.Push(node)
+	if err != nil {
+		b.base.Push(xml.StartElement{Name: node.Name})
+	}
+	return err
+}
+
+func (b *BaseHandler) pop() {
+	b.path.Pop()
+	b.base.Pop()
+}
+
+// Trackable is implemented by handlers embedding a *BaseHandler
+type Trackable interface {
+	Handler
+	baseHandler() *BaseHandler
+}
+
+// Track returns a Handler that maintains the path, namespaces and
+// xml:base of the BaseHandler embedded in h around each event passed
+// on to h.  Transform applies Track to a Trackable handler passed to
+// it directly.
+//
+// An element whose StartElement fails is popped again when the
+// transform is run with RecoverSkip or RecoverResync, as its content
+// and EndElement are skipped.  With RecoverContinue it remains the
+// current element until its EndElement, which is passed on to h only
+// if its StartElement was: an element whose xml:base cannot be parsed
+// is not passed on to h at all.
+func Track(h Trackable) Handler {
+	return &tracker{Filter: &Filter{Next: h}, b: h.baseHandler()}
+}
+
+// tracker is the Handler returned by Track
+type tracker struct {
+	*Filter
+	b      *BaseHandler
+	skip   bool   // the content of an element whose start fails is skipped
+	passed []bool // whether the StartElement of each open element was passed on
+}
+
+// SetOptions sets Raw from opts.RawTokens, and passes opts on to h
+func (t *tracker) SetOptions(opts *TransformOptions) {
+	t.b.Raw = opts.RawTokens
+	t.skip = opts.Recovery >= RecoverSkip
+	t.Filter.SetOptions(opts)
+}
+
+func (t *tracker) StartElement(node xml.StartElement) error {
+	err := t.b.push(node)
+	passed := err == nil
+	if passed {
+		err = t.Filter.StartElement(node)
+	}
+	if err != nil && t.skip {
+		t.b.pop()
+		return err
+	}
+	t.passed = append(t.passed, passed)
+	return err
+}
+
+// CData passes node on to h, which is an IdentityTransform and so a
+// CDataHandler
+func (t *tracker) CData(node CData) error {
+	return t.Emit(node)
+}
+
+func (t *tracker) EndElement(node xml.EndElement) error {
+	n := len(t.passed)
+	if n == 0 {
+		return t.Filter.EndElement(node)
+	}
+	passed := t.passed[n-1]
+	t.passed = t.passed[:n-1]
+
+	var err error
+	if passed {
+		err = t.Filter.EndElement(node)
+	}
+	t.b.pop()
+	return err
+}
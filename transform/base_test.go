@@ -0,0 +1,237 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const xhtmlSpace = "http://www.w3.org/1999/xhtml"
+
+// baseHandler resolves the href attributes of xhtml elements, records
+// the path and base of each element, and fails on elements named bad
+// or with a fail attribute, dropping their end tags
+type baseHandler struct {
+	*BaseHandler
+	seen []string
+}
+
+// failing reports whether the current element is one to fail on
+func (h *baseHandler) failing() bool {
+	_, fail := h.Path().Attr(xml.Name{Local: "fail"})
+	return fail || h.Path().Name().Local == "bad"
+}
+
+func (h *baseHandler) StartElement(node xml.StartElement) error {
+	if h.failing() {
+		return errBad
+	}
+	base, err := h.Base().URL().String()
+	if err != nil {
+		return err
+	}
+	h.seen = append(h.seen, fmt.Sprintf("%s %s", h.Path(), base))
+	name := h.Namespaces().Translate(node.Name, true)
+	for i, attr := range node.Attr {
+		if name.Space == xhtmlSpace && attr.Name.Space == "" && attr.Name.Local == "href" {
+			iri, err := h.Base().Resolve(attr.Value)
+			if err != nil {
+				return err
+			}
+			node.Attr[i].Value = iri
+		}
+	}
+	return h.BaseHandler.StartElement(node)
+}
+
+func (h *baseHandler) EndElement(node xml.EndElement) error {
+	h.seen = append(h.seen, fmt.Sprintf("end %s %s", h.Path(), h.Namespaces().Prefix(xhtmlSpace)))
+	if h.failing() {
+		return nil
+	}
+	return h.BaseHandler.EndElement(node)
+}
+
+func (h *baseHandler) Error(err error) (abort bool) {
+	return false
+}
+
+type baseTest struct {
+	descr    string
+	raw      bool
+	recovery Recovery
+	input    string
+	output   string
+	seen     []string
+}
+
+var baseTests = []baseTest{
+	{
+		"Track path, namespaces and base",
+		false,
+		RecoverContinue,
+		`<h:p xmlns:h="http://www.w3.org/1999/xhtml" xml:base="a/"><h:a href="x"/><q xml:base="/b/"><h:a href="y"/></q></h:p>`,
+		`<h:p xmlns:h='http://www.w3.org/1999/xhtml' xml:base='a/'><h:a href='http://example.com/a/x'></h:a><q xml:base='/b/'><h:a href='http://example.com/b/y'></h:a></q></h:p>`,
+		[]string{
+			"/h:p http://example.com/a/",
+			"/h:p/h:a http://example.com/a/",
+			"end /h:p/h:a h",
+			"/h:p/q http://example.com/b/",
+			"/h:p/q/h:a http://example.com/b/",
+			"end /h:p/q/h:a h",
+			"end /h:p/q h",
+			"end /h:p h",
+		},
+	},
+	{
+		"Track raw tokens",
+		true,
+		RecoverContinue,
+		`<h:p xmlns:h="http://www.w3.org/1999/xhtml" xml:base="a/"><h:a href="x"/></h:p>`,
+		`<h:p xmlns:h='http://www.w3.org/1999/xhtml' xml:base='a/'><h:a href='http://example.com/a/x'></h:a></h:p>`,
+		[]string{
+			"/h:p http://example.com/a/",
+			"/h:p/h:a http://example.com/a/",
+			"end /h:p/h:a h",
+			"end /h:p h",
+		},
+	},
+	{
+		"Skipped element is popped",
+		false,
+		RecoverSkip,
+		`<a><bad><c/></bad><d/></a>`,
+		`<a><d></d></a>`,
+		[]string{
+			"/a http://example.com/",
+			"/a/d http://example.com/",
+			"end /a/d ",
+			"end /a ",
+		},
+	},
+	{
+		"Failed element is current until its end",
+		false,
+		RecoverContinue,
+		`<a><bad><c/></bad><d/></a>`,
+		`<a><c></c><d></d></a>`,
+		[]string{
+			"/a http://example.com/",
+			"/a/bad/c http://example.com/",
+			"end /a/bad/c ",
+			"end /a/bad ",
+			"/a/d http://example.com/",
+			"end /a/d ",
+			"end /a ",
+		},
+	},
+	{
+		"Failed element named as its parent",
+		false,
+		RecoverContinue,
+		`<a><a fail="1"><c/></a><d/></a>`,
+		`<a><c></c><d></d></a>`,
+		[]string{
+			"/a http://example.com/",
+			"/a/a/c http://example.com/",
+			"end /a/a/c ",
+			"end /a/a ",
+			"/a/d http://example.com/",
+			"end /a/d ",
+			"end /a ",
+		},
+	},
+	{
+		"Element with an invalid xml:base is dropped",
+		false,
+		RecoverContinue,
+		`<a><b xml:base="http://[::1"><c/></b><d/></a>`,
+		`<a><c></c><d></d></a>`,
+		[]string{
+			"/a http://example.com/",
+			"/a/b/c http://example.com/",
+			"end /a/b/c ",
+			"/a/d http://example.com/",
+			"end /a/d ",
+			"end /a ",
+		},
+	},
+	{
+		"Element with an invalid xml:base is skipped",
+		false,
+		RecoverSkip,
+		`<a><b xml:base="http://[::1"><c/></b><d/></a>`,
+		`<a><d></d></a>`,
+		[]string{
+			"/a http://example.com/",
+			"/a/d http://example.com/",
+			"end /a/d ",
+			"end /a ",
+		},
+	},
+}
+
+func TestBaseHandler(t *testing.T) {
+	for _, v := range baseTests {
+		w := new(bytes.Buffer)
+		b, err := NewBaseHandler(w, "http://example.com/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := &baseHandler{BaseHandler: b}
+
+		opts := NewTransformOptions()
+		opts.RawTokens = v.raw
+		opts.Recovery = v.recovery
+		if err = TransformWithOptions(strings.NewReader(v.input), h, opts); err != nil {
+			t.Errorf("%s: %v", v.descr, err)
+		}
+		if w.String() != v.output {
+			t.Errorf("%s: expected %s, got %s", v.descr, v.output, w.String())
+		}
+		if strings.Join(h.seen, "\n") != strings.Join(v.seen, "\n") {
+			t.Errorf("%s: expected\n%s\ngot\n%s", v.descr, strings.Join(v.seen, "\n"), strings.Join(h.seen, "\n"))
+		}
+		if d := h.Path().Depth(); d != 0 {
+			t.Errorf("%s: expected an empty path, got depth %d", v.descr, d)
+		}
+	}
+}
+
+func TestTrackPipeline(t *testing.T) {
+	w := new(bytes.Buffer)
+	b, err := NewBaseHandler(w, "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &baseHandler{BaseHandler: b}
+
+	opts := NewTransformOptions()
+	opts.RawTokens = true
+	input := `<h:p xmlns:h="http://www.w3.org/1999/xhtml" xml:base="a/"><h:a href="x"/></h:p>`
+	if err = TransformWithOptions(strings.NewReader(input), NewPipeline(Track(h), &Filter{}), opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<h:p xmlns:h='http://www.w3.org/1999/xhtml' xml:base='a/'><h:a href='http://example.com/a/x'></h:a></h:p>`
+	if w.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+}
+
+func TestTrackCData(t *testing.T) {
+	w := new(bytes.Buffer)
+	b, err := NewBaseHandler(w, "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := NewTransformOptions()
+	opts.CDATA = true
+	if err = TransformWithOptions(strings.NewReader(cdataInput), &baseHandler{BaseHandler: b}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != cdataInput {
+		t.Errorf("expected %s, got %s", cdataInput, w.String())
+	}
+}
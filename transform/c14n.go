@@ -0,0 +1,355 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/jimrobinson/xml/xmlns"
+)
+
+// Canonicalizer implements a Handler that writes the canonical form
+// of a document, as defined by Canonical XML Version 1.0
+// (http://www.w3.org/TR/2001/REC-xml-c14n-20010315) or, if Exclusive
+// is set, by Exclusive XML Canonicalization Version 1.0
+// (http://www.w3.org/TR/2002/REC-xml-exc-c14n-20020718/).
+//
+// The canonical form is produced for the document as a whole, as
+// reported by the parser: the XML declaration and document type
+// declaration are removed, empty elements are written as start and
+// end tag pairs, attributes are sorted, namespace declarations are
+// sorted and pruned, and attribute values and character data are
+// escaped in the canonical manner.  Because encoding/xml does not
+// process the DTD, default attributes are not added and attribute
+// values are not normalized by type.
+//
+// The canonical form keeps the namespace prefixes of the document,
+// which xml.Decoder.Token does not report.  A Canonicalizer passed to
+// Transform reads raw tokens for that reason, whatever the setting of
+// TransformOptions.RawTokens.  When it is the last stage of a
+// Pipeline, run the transform with RawTokens and set Raw.
+type Canonicalizer struct {
+	// WithComments retains comments in the canonical form
+	WithComments bool
+
+	// Exclusive selects Exclusive XML Canonicalization, in which
+	// only visibly utilized namespace declarations are rendered
+	Exclusive bool
+
+	// InclusivePrefixes lists the namespace prefixes that are
+	// handled using the Canonical XML rules when Exclusive is set
+	// (the InclusiveNamespaces PrefixList).  The default namespace
+	// is given as "#default".
+	InclusivePrefixes []string
+
+	// Raw, if true, causes names to be read with Name.Space as the
+	// prefix, as reported by xml.Decoder.RawToken.  If false, the
+	// prefix of a name is looked up from its namespace uri, and so
+	// may differ from the one written when a namespace is mapped by
+	// more than one prefix.
+	Raw bool
+
+	w        io.Writer
+	err      error
+	ns       *xmlns.XmlNamespace
+	names    []string // qualified names of open elements
+	rendered []nsDecl // namespace declarations rendered by open elements
+	marks    []int    // len(rendered) at the start of each open element
+	root     bool     // the document element has been started
+}
+
+// nsDecl is a rendered namespace declaration
+type nsDecl struct {
+	prefix string
+	uri    string
+}
+
+// canonicalAttr is an attribute ready to be sorted and written
+type canonicalAttr struct {
+	qname string
+	space string // namespace uri
+	attr  xml.Attr
+}
+
+var c14nEscape = EscapePolicy{Quote: '"', MinimalQuotes: true, NamedEntities: true}
+
+const c14nDefault = "#default"
+
+func NewCanonicalizer(w io.Writer) *Canonicalizer {
+	return &Canonicalizer{
+		w:  w,
+		ns: xmlns.NewXmlNamespace(),
+	}
+}
+
+func (c *Canonicalizer) write(b []byte) {
+	if c.err == nil {
+		_, c.err = c.w.Write(b)
+	}
+}
+
+func (c *Canonicalizer) writeString(s string) {
+	if c.err == nil {
+		_, c.err = io.WriteString(c.w, s)
+	}
+}
+
+func (c *Canonicalizer) escape(b []byte, t NodeType) {
+	if c.err == nil {
+		c.err = c14nEscape.Escape(c.w, b, t)
+	}
+}
+
+// lookup returns the namespace uri most recently rendered for prefix
+func (c *Canonicalizer) lookup(prefix string) string {
+	for i := len(c.rendered) - 1; i >= 0; i-- {
+		if c.rendered[i].prefix == prefix {
+			return c.rendered[i].uri
+		}
+	}
+	return ""
+}
+
+// qname returns the qualified name for name in the current scope.
+// Attributes in a namespace require a non-empty prefix.
+func (c *Canonicalizer) qname(name xml.Name, attr bool) (prefix, qname string) {
+	if c.Raw {
+		if name.Space == "" {
+			return "", name.Local
+		}
+		return name.Space, name.Space + ":" + name.Local
+	}
+
+	switch {
+	case name.Space == "":
+		return "", name.Local
+	case name.Space == xmlSpace:
+		return xmlPrefix, xmlPrefix + ":" + name.Local
+	}
+
+	if m := c.ns.InScope(); m != nil {
+		for _, p := range m.Uri[name.Space] {
+			if p != "" || !attr {
+				prefix = p
+				break
+			}
+		}
+	}
+	if prefix == "" {
+		return "", name.Local
+	}
+	return prefix, prefix + ":" + name.Local
+}
+
+// space returns the namespace uri of an attribute name
+func (c *Canonicalizer) space(name xml.Name) (string, error) {
+	if !c.Raw || name.Space == "" {
+		return name.Space, nil
+	}
+	uri, ok := c.ns.URI(name.Space)
+	if !ok {
+		return "", fmt.Errorf("unmapped namespace prefix: %s", name.Space)
+	}
+	return uri, nil
+}
+
+func (c *Canonicalizer) StartElement(node xml.StartElement) error {
+	c.root = true
+	c.ns.Push(node)
+
+	var scope xmlns.Prefix
+	if m := c.ns.InScope(); m != nil {
+		scope = m.Prefix
+	}
+
+	prefix, qname := c.qname(node.Name, false)
+	if _, ok := scope[prefix]; c.Raw && prefix != "" && prefix != xmlPrefix && !ok {
+		c.ns.Pop()
+		return fmt.Errorf("unmapped namespace prefix: %s", prefix)
+	}
+
+	attrs := make([]canonicalAttr, 0, len(node.Attr))
+	utilized := []string{prefix}
+	for _, attr := range node.Attr {
+		if attr.Name.Space == xmlnsPrefix || (attr.Name.Space == "" && attr.Name.Local == xmlnsPrefix) {
+			continue
+		}
+		p, q := c.qname(attr.Name, true)
+		if p != "" && p != xmlPrefix {
+			utilized = append(utilized, p)
+		}
+		space, err := c.space(attr.Name)
+		if err != nil {
+			c.ns.Pop()
+			return err
+		}
+		attrs = append(attrs, canonicalAttr{qname: q, space: space, attr: attr})
+	}
+
+	// select the candidate namespace declarations
+	var candidates []string
+	if c.Exclusive {
+		candidates = utilized
+		for _, p := range c.InclusivePrefixes {
+			if p == c14nDefault {
+				p = ""
+			}
+			if _, ok := scope[p]; ok || p == "" {
+				candidates = append(candidates, p)
+			}
+		}
+	} else {
+		candidates = append(candidates, "")
+		for p := range scope {
+			candidates = append(candidates, p)
+		}
+	}
+
+	// render the declarations that differ from those already in
+	// effect in the output
+	mark := len(c.rendered)
+	c.marks = append(c.marks, mark)
+	for _, p := range candidates {
+		uri := scope[p]
+		if c.lookup(p) == uri {
+			continue
+		}
+		dup := false
+		for _, d := range c.rendered[mark:] {
+			if d.prefix == p {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			c.rendered = append(c.rendered, nsDecl{prefix: p, uri: uri})
+		}
+	}
+	decls := c.rendered[mark:]
+	sort.Slice(decls, func(i, j int) bool {
+		return decls[i].prefix < decls[j].prefix
+	})
+
+	sort.Slice(attrs, func(i, j int) bool {
+		a, b := attrs[i], attrs[j]
+		if a.space != b.space {
+			return a.space < b.space
+		}
+		return a.attr.Name.Local < b.attr.Name.Local
+	})
+
+	c.names = append(c.names, qname)
+
+	c.write(startStartElement)
+	c.writeString(qname)
+	for _, d := range decls {
+		c.write(space)
+		if d.prefix == "" {
+			c.writeString(xmlnsPrefix)
+		} else {
+			c.write(xmlnsDecl)
+			c.writeString(d.prefix)
+		}
+		c.write(startAttrQuot)
+		c.escape([]byte(d.uri), AttrValue)
+		c.write(endAttrQuot)
+	}
+	for _, a := range attrs {
+		c.write(space)
+		c.writeString(a.qname)
+		c.write(startAttrQuot)
+		c.escape([]byte(a.attr.Value), AttrValue)
+		c.write(endAttrQuot)
+	}
+	c.write(endStartElement)
+	return c.err
+}
+
+func (c *Canonicalizer) EndElement(node xml.EndElement) error {
+	n := len(c.names) - 1
+	if n < 0 {
+		return c.err
+	}
+	if c.Raw {
+		// xml.Decoder.RawToken does not match end tags to start tags
+		if _, qname := c.qname(node.Name, false); qname != c.names[n] {
+			return fmt.Errorf("element <%s> closed by </%s>", c.names[n], qname)
+		}
+	}
+
+	c.write(startEndElement)
+	c.writeString(c.names[n])
+	c.write(endEndElement)
+
+	c.names = c.names[:n]
+	c.rendered = c.rendered[:c.marks[n]]
+	c.marks = c.marks[:n]
+	c.ns.Pop()
+	return c.err
+}
+
+func (c *Canonicalizer) CharData(node xml.CharData) error {
+	if len(c.names) > 0 {
+		c.escape(node, CharData)
+	}
+	return c.err
+}
+
+// beforeNode writes the line break required before a comment or
+// processing instruction that follows the document element
+func (c *Canonicalizer) beforeNode() {
+	if len(c.names) == 0 && c.root {
+		c.write(newline)
+	}
+}
+
+// afterNode writes the line break required after a comment or
+// processing instruction that precedes the document element
+func (c *Canonicalizer) afterNode() {
+	if len(c.names) == 0 && !c.root {
+		c.write(newline)
+	}
+}
+
+func (c *Canonicalizer) Comment(node xml.Comment) error {
+	if !c.WithComments {
+		return c.err
+	}
+	c.beforeNode()
+	c.write(startComment)
+	c.write(node)
+	c.write(endComment)
+	c.afterNode()
+	return c.err
+}
+
+// Directive discards the document type declaration
+func (c *Canonicalizer) Directive(node xml.Directive) error {
+	return c.err
+}
+
+func (c *Canonicalizer) ProcInst(node xml.ProcInst) error {
+	if node.Target == xmlPrefix {
+		return c.err
+	}
+	c.beforeNode()
+	c.write(startProcInst)
+	c.writeString(node.Target)
+	if inst := bytes.TrimLeft(node.Inst, " \t\r\n"); len(inst) > 0 {
+		c.write(space)
+		c.write(inst)
+	}
+	c.write(endProcInst)
+	c.afterNode()
+	return c.err
+}
+
+func (c *Canonicalizer) Error(err error) (abort bool) {
+	return true
+}
+
+func (c *Canonicalizer) Flush() error {
+	return c.err
+}
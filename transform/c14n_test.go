@@ -0,0 +1,213 @@
+package transform
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type c14nTest struct {
+	descr        string
+	exclusive    bool
+	withComments bool
+	inclusive    []string
+	input        string
+	output       string
+}
+
+var c14nTests = []c14nTest{
+	{
+		"PIs, Comments, and Outside of Document Element",
+		false,
+		false,
+		nil,
+		`<?xml version="1.0"?>
+
+<?xml-stylesheet   href="doc.xsl"
+   type="text/xsl"   ?>
+
+<!DOCTYPE doc SYSTEM "doc.dtd">
+
+<doc>Hello, world!<!-- Comment 1 --></doc>
+
+<?pi-without-data     ?>
+
+<!-- Comment 2 -->
+
+<!-- Comment 3 -->`,
+		`<?xml-stylesheet href="doc.xsl"
+   type="text/xsl"   ?>
+<doc>Hello, world!</doc>
+<?pi-without-data?>`,
+	},
+	{
+		"PIs, Comments, and Outside of Document Element (with comments)",
+		false,
+		true,
+		nil,
+		`<?xml version="1.0"?>
+
+<?xml-stylesheet   href="doc.xsl"
+   type="text/xsl"   ?>
+
+<!DOCTYPE doc SYSTEM "doc.dtd">
+
+<doc>Hello, world!<!-- Comment 1 --></doc>
+
+<?pi-without-data     ?>
+
+<!-- Comment 2 -->
+
+<!-- Comment 3 -->`,
+		`<?xml-stylesheet href="doc.xsl"
+   type="text/xsl"   ?>
+<doc>Hello, world!<!-- Comment 1 --></doc>
+<?pi-without-data?>
+<!-- Comment 2 -->
+<!-- Comment 3 -->`,
+	},
+	{
+		"Start and End Tags",
+		false,
+		false,
+		nil,
+		`<!DOCTYPE doc []>
+<doc>
+   <e1   />
+   <e2   ></e2>
+   <e3   name = "elem3"   id="elem3"   />
+   <e4   name="elem4"   id="elem4"   ></e4>
+   <e5 a:attr="out" b:attr="sorted" attr2="all" attr="I'm"
+      xmlns:b="http://www.ietf.org"
+      xmlns:a="http://www.w3.org"
+      xmlns="http://example.org"/>
+   <e6 xmlns="" xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="" xmlns:a="http://www.w3.org">
+            <e9 xmlns="" xmlns:a="http://www.ietf.org" attr="default"/>
+         </e8>
+      </e7>
+   </e6>
+</doc>`,
+		`<doc>
+   <e1></e1>
+   <e2></e2>
+   <e3 id="elem3" name="elem3"></e3>
+   <e4 id="elem4" name="elem4"></e4>
+   <e5 xmlns="http://example.org" xmlns:a="http://www.w3.org" xmlns:b="http://www.ietf.org" attr="I'm" attr2="all" b:attr="sorted" a:attr="out"></e5>
+   <e6 xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="">
+            <e9 xmlns:a="http://www.ietf.org" attr="default"></e9>
+         </e8>
+      </e7>
+   </e6>
+</doc>`,
+	},
+	{
+		"Character Modifications and Character References",
+		false,
+		false,
+		nil,
+		`<doc>
+   <text>First line&#x0d;&#10;Second line</text>
+   <value>&#x32;</value>
+   <compute><![CDATA[value>"0" && value<"10" ?"valid":"error"]]></compute>
+   <compute expr='value>"0" &amp;&amp; value&lt;"10" ?"valid":"error"'>valid</compute>
+   <norm attr=' &apos;   &#x20;&#13;&#xa;&#9;   &apos; '/>
+</doc>`,
+		`<doc>
+   <text>First line&#xD;
+Second line</text>
+   <value>2</value>
+   <compute>value&gt;"0" &amp;&amp; value&lt;"10" ?"valid":"error"</compute>
+   <compute expr="value>&quot;0&quot; &amp;&amp; value&lt;&quot;10&quot; ?&quot;valid&quot;:&quot;error&quot;">valid</compute>
+   <norm attr=" '    &#xD;&#xA;&#x9;   ' "></norm>
+</doc>`,
+	},
+	{
+		"Inclusive namespaces",
+		false,
+		false,
+		nil,
+		`<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`,
+		`<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff></n3:stuff></n1:elem2></n0:local>`,
+	},
+	{
+		"Exclusive namespaces",
+		true,
+		false,
+		nil,
+		`<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`,
+		`<n0:local xmlns:n0="foo:bar"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2></n0:local>`,
+	},
+	{
+		"Exclusive namespaces with an inclusive prefix list",
+		true,
+		false,
+		[]string{"n3", "#default"},
+		`<n0:local xmlns="urn:d" xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`,
+		`<n0:local xmlns="urn:d" xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff></n3:stuff></n1:elem2></n0:local>`,
+	},
+	{
+		"Exclusive default namespace",
+		true,
+		false,
+		nil,
+		`<a xmlns="urn:a"><b xmlns=""><c xmlns="urn:a"/></b></a>`,
+		`<a xmlns="urn:a"><b xmlns=""><c xmlns="urn:a"></c></b></a>`,
+	},
+	{
+		"Default namespace also mapped by a prefix",
+		false,
+		false,
+		nil,
+		`<a xmlns="urn:x" xmlns:p="urn:x"><p:c p:d="1"/><c/></a>`,
+		`<a xmlns="urn:x" xmlns:p="urn:x"><p:c p:d="1"></p:c><c></c></a>`,
+	},
+	{
+		"Namespace mapped by two prefixes",
+		false,
+		false,
+		nil,
+		`<p:a xmlns:p="urn:x" xmlns:q="urn:x"><q:b/><p:b/></p:a>`,
+		`<p:a xmlns:p="urn:x" xmlns:q="urn:x"><q:b></q:b><p:b></p:b></p:a>`,
+	},
+	{
+		"Exclusive namespace mapped by two prefixes",
+		true,
+		false,
+		nil,
+		`<p:a xmlns:p="urn:x" xmlns:q="urn:x"><q:b/></p:a>`,
+		`<p:a xmlns:p="urn:x"><q:b xmlns:q="urn:x"></q:b></p:a>`,
+	},
+}
+
+func TestCanonicalizer(t *testing.T) {
+	for _, v := range c14nTests {
+		w := new(bytes.Buffer)
+		c := NewCanonicalizer(w)
+		c.Exclusive = v.exclusive
+		c.WithComments = v.withComments
+		c.InclusivePrefixes = v.inclusive
+		if err := Transform(strings.NewReader(v.input), c); err != nil {
+			t.Errorf("%s: %v", v.descr, err)
+			continue
+		}
+		if w.String() != v.output {
+			t.Errorf("%s: expected\n%s\ngot\n%s", v.descr, v.output, w.String())
+		}
+	}
+}
+
+func TestCanonicalizerErrors(t *testing.T) {
+	for _, input := range []string{
+		`<a><b></a></b>`,
+		`<p:a/>`,
+		`<a p:b="1"/>`,
+	} {
+		if err := Transform(strings.NewReader(input), NewCanonicalizer(new(bytes.Buffer))); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
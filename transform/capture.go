@@ -0,0 +1,280 @@
+package transform
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/jimrobinson/xml/xmlpath"
+)
+
+// Node is an element of a subtree captured by a Router, which may be
+// examined and modified before it is emitted.  Children holds the
+// content of the element: *Node for child elements, and xml.CharData,
+// CData, xml.Comment, xml.ProcInst or xml.Directive for the rest.
+//
+// Names are as reported by the decoder: when the transform is run
+// with TransformOptions.RawTokens, Name.Space holds the prefix as
+// written rather than a namespace uri.
+type Node struct {
+	Name     xml.Name
+	Attr     []xml.Attr
+	Children []xml.Token
+
+	parent *Node
+	raw    bool       // names are as reported by xml.Decoder.RawToken
+	scope  []xml.Attr // namespace declarations in scope of a raw root
+}
+
+// NewNode returns a Node for the element started by node
+func NewNode(node xml.StartElement) *Node {
+	node = node.Copy()
+	return &Node{Name: node.Name, Attr: node.Attr}
+}
+
+// StartElement returns the start of the element
+func (n *Node) StartElement() xml.StartElement {
+	return xml.StartElement{Name: n.Name, Attr: n.Attr}
+}
+
+// EndElement returns the end of the element
+func (n *Node) EndElement() xml.EndElement {
+	return xml.EndElement{Name: n.Name}
+}
+
+// Append adds children to the content of the element
+func (n *Node) Append(children ...xml.Token) {
+	for _, child := range children {
+		if c, ok := child.(*Node); ok {
+			c.parent = n
+			c.raw = n.raw
+		}
+		n.Children = append(n.Children, child)
+	}
+}
+
+// Elements returns the child elements of the element
+func (n *Node) Elements() []*Node {
+	var elements []*Node
+	for _, child := range n.Children {
+		if c, ok := child.(*Node); ok {
+			elements = append(elements, c)
+		}
+	}
+	return elements
+}
+
+// Element returns the first child element named name, or nil
+func (n *Node) Element(name xml.Name) *Node {
+	for _, child := range n.Children {
+		if c, ok := child.(*Node); ok && c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// AttrValue returns the value of the attribute named name
+func (n *Node) AttrValue(name xml.Name) (value string, ok bool) {
+	for _, attr := range n.Attr {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetAttr sets the value of the attribute named name, adding it if
+// need be
+func (n *Node) SetAttr(name xml.Name, value string) {
+	for i, attr := range n.Attr {
+		if attr.Name == name {
+			n.Attr[i].Value = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, xml.Attr{Name: name, Value: value})
+}
+
+// Text returns the character data within the element and its
+// descendants
+func (n *Node) Text() string {
+	var b strings.Builder
+	n.text(&b)
+	return b.String()
+}
+
+func (n *Node) text(b *strings.Builder) {
+	for _, child := range n.Children {
+		switch c := child.(type) {
+		case *Node:
+			c.text(b)
+		case xml.CharData:
+			b.Write(c)
+		case CData:
+			b.Write(c)
+		}
+	}
+}
+
+// Select returns the elements of the subtree rooted at n, including
+// n, that match p.  The path of n is taken to be the root of the
+// document, so the absolute pattern /atom:entry/atom:id selects the
+// id children of a captured entry.
+func (n *Node) Select(p *xmlpath.Pattern) []*Node {
+	xp := xmlpath.NewXmlPath()
+	if n.raw {
+		// the prefixes of a raw subtree may be declared by
+		// elements outside of it
+		xp.Namespaces().Push(xml.StartElement{Attr: n.inScope()})
+	}
+	m := xp.Watch(p)
+
+	var selected []*Node
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n.raw {
+			xp.PushRaw(n.StartElement())
+		} else {
+			xp.Push(n.StartElement())
+		}
+		if m.Matched() {
+			selected = append(selected, n)
+		}
+		for _, child := range n.Children {
+			if c, ok := child.(*Node); ok {
+				walk(c)
+			}
+		}
+		xp.Pop()
+	}
+	walk(n)
+	return selected
+}
+
+// inScope returns the namespace declarations in scope of the parent
+// of n
+func (n *Node) inScope() (scope []xml.Attr) {
+	var ancestors []*Node
+	root := n
+	for ; root.parent != nil; root = root.parent {
+		ancestors = append(ancestors, root.parent)
+	}
+	scope = append(scope, root.scope...)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		for _, attr := range ancestors[i].Attr {
+			if attr.Name.Space == xmlnsPrefix || (attr.Name.Space == "" && attr.Name.Local == xmlnsPrefix) {
+				scope = append(scope, attr)
+			}
+		}
+	}
+	return
+}
+
+// Emit passes the element and its content to out
+func (n *Node) Emit(out Emitter) error {
+	if err := out.Emit(n.StartElement()); err != nil {
+		return err
+	}
+	for _, child := range n.Children {
+		var err error
+		if c, ok := child.(*Node); ok {
+			err = c.Emit(out)
+		} else {
+			err = out.Emit(child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return out.Emit(n.EndElement())
+}
+
+// CaptureFunc handles a subtree captured by a Router.  It passes
+// tokens downstream by calling out.Emit, typically with n.Emit(out)
+// once n has been examined or modified; a subtree that is not emitted
+// is dropped.
+type CaptureFunc func(n *Node, out Emitter) error
+
+type captureRoute struct {
+	m *xmlpath.Match
+	f CaptureFunc
+}
+
+// Capture routes the elements matching pattern, along with their
+// content, to f.  Each matching element is buffered as a tree of
+// Nodes, which is passed to f once the element ends.  Only the
+// matching subtrees are held in memory.  Events within a subtree
+// being captured are not routed to other functions, and a capture
+// takes precedence over a Start function matching the same element.
+func (r *Router) Capture(pattern string, f CaptureFunc) error {
+	m, err := r.watch(pattern)
+	if err == nil {
+		r.captures = append(r.captures, captureRoute{m, f})
+	}
+	return err
+}
+
+// capture starts capturing node, if it matches a capture pattern
+func (r *Router) capture(node xml.StartElement) bool {
+	for _, route := range r.captures {
+		if route.m.Matched() {
+			root := NewNode(node)
+			root.raw = r.Raw
+			if r.Raw {
+				root.scope = r.scope()
+			}
+			r.open = []*Node{root}
+			r.captured = route.f
+			return true
+		}
+	}
+	return false
+}
+
+// scope returns the namespace declarations in scope of the current
+// element
+func (r *Router) scope() (scope []xml.Attr) {
+	m := r.path.Namespaces().InScope()
+	if m == nil {
+		return
+	}
+	for prefix, uri := range m.Prefix {
+		name := xml.Name{Space: xmlnsPrefix, Local: prefix}
+		if prefix == "" {
+			name = xml.Name{Local: xmlnsPrefix}
+		}
+		scope = append(scope, xml.Attr{Name: name, Value: uri})
+	}
+	return
+}
+
+// capturing reports whether a subtree is being captured
+func (r *Router) capturing() bool {
+	return len(r.open) > 0
+}
+
+// add adds tok to the element being captured
+func (r *Router) add(tok xml.Token) error {
+	r.open[len(r.open)-1].Append(copyToken(tok))
+	return nil
+}
+
+// startNode adds an element to the subtree being captured
+func (r *Router) startNode(node xml.StartElement) {
+	n := NewNode(node)
+	r.open[len(r.open)-1].Append(n)
+	r.open = append(r.open, n)
+}
+
+// endNode ends the current element of the subtree being captured,
+// passing the subtree on once its root ends
+func (r *Router) endNode() error {
+	root := r.open[0]
+	r.open = r.open[:len(r.open)-1]
+	if len(r.open) > 0 {
+		return nil
+	}
+	f := r.captured
+	r.captured = nil
+	return f(root, &r.Filter)
+}
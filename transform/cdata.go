@@ -0,0 +1,95 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"unicode/utf8"
+)
+
+// CData is a token representing a CDATA section.  Transform reports
+// CDATA sections as CData, rather than as xml.CharData, when run
+// with TransformOptions.CDATA.
+type CData []byte
+
+// CDataHandler may be implemented by a Handler that wants to receive
+// CDATA sections.  Dispatch passes a CData token to a Handler that is
+// not a CDataHandler as xml.CharData.
+type CDataHandler interface {
+	// CData will be called when the parser reports a CDATA section
+	CData(CData) error
+}
+
+var startCData = []byte("<![CDATA[")
+var endCData = []byte("]]>")
+
+// CData writes node as a CDATA section.  An occurrence of "]]>" in
+// node is split across two sections, and characters that cannot be
+// represented in the output Charset are written as numeric character
+// references between sections.
+func (t *IdentityTransform) CData(node CData) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.begin()
+	if t.Indent != "" {
+		if n := len(t.levels); n > 0 {
+			t.levels[n-1].mixed = true
+		}
+		t.writeSpace()
+	}
+	t.closeStart()
+
+	t.write(startCData)
+	b := []byte(node)
+	for t.err == nil {
+		i := bytes.Index(b, endCData)
+		if i < 0 {
+			t.writeCData(b)
+			break
+		}
+		t.writeCData(b[:i+2])
+		t.write(endCData)
+		t.write(startCData)
+		b = b[i+2:]
+	}
+	t.write(endCData)
+	return t.err
+}
+
+// writeCData writes the content of a CDATA section
+func (t *IdentityTransform) writeCData(b []byte) {
+	if t.Charset == nil {
+		t.write(b)
+		return
+	}
+	for len(b) > 0 && t.err == nil {
+		i := 0
+		for i < len(b) {
+			r, n := utf8.DecodeRune(b[i:])
+			if _, ok := t.Charset.Encode(r); !ok {
+				break
+			}
+			i += n
+		}
+		t.write(b[:i])
+		b = b[i:]
+		if len(b) == 0 {
+			break
+		}
+
+		r, n := utf8.DecodeRune(b)
+		t.write(endCData)
+		t.writeString("&#" + strconv.Itoa(int(r)) + ";")
+		t.write(startCData)
+		b = b[n:]
+	}
+}
+
+// copyToken is like xml.CopyToken, and also copies CData
+func copyToken(tok xml.Token) xml.Token {
+	if node, ok := tok.(CData); ok {
+		return CData(append([]byte(nil), node...))
+	}
+	return xml.CopyToken(tok)
+}
@@ -0,0 +1,151 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+const cdataInput = `<a>if <![CDATA[a < b && c > d]]> then <![CDATA[]]></a>`
+
+// upper upper-cases character data and CDATA sections
+var upper = TokenHandlerFunc(func(tok xml.Token, out Emitter) error {
+	switch node := tok.(type) {
+	case xml.CharData:
+		return out.Emit(xml.CharData(bytes.ToUpper(node)))
+	case CData:
+		return out.Emit(CData(bytes.ToUpper(node)))
+	}
+	return out.Emit(tok)
+})
+
+// redact replaces character data with x's
+type redact struct {
+	Filter
+}
+
+func (r *redact) CharData(node xml.CharData) error {
+	return r.Filter.CharData(bytes.Repeat([]byte("x"), len(node)))
+}
+
+// cdataStage returns a TokenStage receiving CDATA sections as CData
+func cdataStage(h TokenHandler) *TokenStage {
+	s := NewTokenStage(h)
+	s.CDATA = true
+	return s
+}
+
+type cdataTest struct {
+	descr  string
+	opts   *TransformOptions
+	h      func(w *bytes.Buffer) Handler
+	input  string
+	output string
+}
+
+var cdataTests = []cdataTest{
+	{
+		"CDATA",
+		&TransformOptions{Strict: true, CDATA: true},
+		func(w *bytes.Buffer) Handler {
+			return NewIdentityTransform(w)
+		},
+		cdataInput,
+		cdataInput,
+	},
+	{
+		"CDATA not reported",
+		NewTransformOptions(),
+		func(w *bytes.Buffer) Handler {
+			return NewIdentityTransform(w)
+		},
+		cdataInput,
+		`<a>if a &lt; b &amp;&amp; c &gt; d then </a>`,
+	},
+	{
+		"Handler without CData",
+		&TransformOptions{Strict: true, CDATA: true},
+		func(w *bytes.Buffer) Handler {
+			return NewCanonicalizer(w)
+		},
+		cdataInput,
+		`<a>if a &lt; b &amp;&amp; c &gt; d then </a>`,
+	},
+	{
+		"Pipeline",
+		&TransformOptions{Strict: true, CDATA: true},
+		func(w *bytes.Buffer) Handler {
+			return NewPipeline(NewIdentityTransform(w), cdataStage(upper))
+		},
+		cdataInput,
+		`<a>IF <![CDATA[A < B && C > D]]> THEN <![CDATA[]]></a>`,
+	},
+	{
+		"TokenStage without CDATA",
+		&TransformOptions{Strict: true, CDATA: true},
+		func(w *bytes.Buffer) Handler {
+			return NewPipeline(NewIdentityTransform(w), NewTokenStage(upper))
+		},
+		cdataInput,
+		`<a>IF A &lt; B &amp;&amp; C &gt; D THEN </a>`,
+	},
+	{
+		"Filter overriding CharData",
+		&TransformOptions{Strict: true, CDATA: true},
+		func(w *bytes.Buffer) Handler {
+			return NewPipeline(NewIdentityTransform(w), &redact{})
+		},
+		cdataInput,
+		`<a>` + strings.Repeat("x", len("if a < b && c > d then ")) + `</a>`,
+	},
+	{
+		"Lossless",
+		&TransformOptions{Strict: true, CDATA: true, KeepOriginal: true},
+		func(w *bytes.Buffer) Handler {
+			return NewPipeline(NewLosslessTransform(w), cdataStage(upper))
+		},
+		`<a x = '1'><![CDATA[<b>]]><![CDATA[keep]]></a>`,
+		`<a x = '1'><![CDATA[<B>]]><![CDATA[KEEP]]></a>`,
+	},
+}
+
+func TestCData(t *testing.T) {
+	for _, v := range cdataTests {
+		w := new(bytes.Buffer)
+		if err := TransformWithOptions(strings.NewReader(v.input), v.h(w), v.opts); err != nil {
+			t.Errorf("%s: %v", v.descr, err)
+			continue
+		}
+		if w.String() != v.output {
+			t.Errorf("%s: expected %s, got %s", v.descr, v.output, w.String())
+		}
+	}
+}
+
+func TestIdentityCData(t *testing.T) {
+	tests := []struct {
+		charset *Charset
+		input   string
+		output  string
+	}{
+		{nil, "a]]>b", "<![CDATA[a]]]]><![CDATA[>b]]>"},
+		{nil, "]]>]]>", "<![CDATA[]]]]><![CDATA[>]]]]><![CDATA[>]]>"},
+		{USASCII, "é<€>", "<![CDATA[]]>&#233;<![CDATA[<]]>&#8364;<![CDATA[>]]>"},
+		{ISO88591, "é€", "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<![CDATA[\xe9]]>&#8364;<![CDATA[]]>"},
+	}
+	for _, v := range tests {
+		w := new(bytes.Buffer)
+		h := NewIdentityTransform(w)
+		h.Charset = v.charset
+		if err := h.Emit(CData(v.input)); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if w.String() != v.output {
+			t.Errorf("%q: expected %q, got %q", v.input, v.output, w.String())
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package transform
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Charset describes a single-byte character encoding whose lower half
+// is US-ASCII.
+type Charset struct {
+	// Name is the preferred MIME name of the encoding, as used in
+	// the XML declaration
+	Name string
+
+	high [128]rune // runes for bytes 0x80 through 0xFF, or utf8.RuneError
+
+	once   sync.Once
+	encode map[rune]byte
+}
+
+// Encode returns the byte representing r in the charset
+func (cs *Charset) Encode(r rune) (b byte, ok bool) {
+	if r < utf8.RuneSelf {
+		return byte(r), true
+	}
+	cs.once.Do(func() {
+		cs.encode = make(map[rune]byte, len(cs.high))
+		for i, r := range cs.high {
+			if r != utf8.RuneError {
+				cs.encode[r] = byte(i + 0x80)
+			}
+		}
+	})
+	b, ok = cs.encode[r]
+	return
+}
+
+// Decode returns the rune represented by b in the charset, or
+// utf8.RuneError if b is not defined in the charset
+func (cs *Charset) Decode(b byte) rune {
+	if b < utf8.RuneSelf {
+		return rune(b)
+	}
+	return cs.high[b-0x80]
+}
+
+var (
+	// USASCII is the US-ASCII encoding
+	USASCII = newCharset("US-ASCII", func(b byte) rune {
+		return utf8.RuneError
+	})
+
+	// ISO88591 is the ISO-8859-1 (Latin-1) encoding
+	ISO88591 = newCharset("ISO-8859-1", func(b byte) rune {
+		return rune(b)
+	})
+
+	// ISO885915 is the ISO-8859-15 (Latin-9) encoding
+	ISO885915 = newCharset("ISO-8859-15", func(b byte) rune {
+		switch b {
+		case 0xA4:
+			return '€'
+		case 0xA6:
+			return 'Š'
+		case 0xA8:
+			return 'š'
+		case 0xB4:
+			return 'Ž'
+		case 0xB8:
+			return 'ž'
+		case 0xBC:
+			return 'Œ'
+		case 0xBD:
+			return 'œ'
+		case 0xBE:
+			return 'Ÿ'
+		}
+		return rune(b)
+	})
+
+	// Windows1252 is the windows-1252 encoding, the superset of
+	// ISO-8859-1 commonly mislabeled as ISO-8859-1
+	Windows1252 = newCharset("windows-1252", func(b byte) rune {
+		if b < 0xA0 {
+			return windows1252[b-0x80]
+		}
+		return rune(b)
+	})
+)
+
+var windows1252 = [32]rune{
+	'€', utf8.RuneError, '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', utf8.RuneError, 'Ž', utf8.RuneError,
+	utf8.RuneError, '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', utf8.RuneError, 'ž', 'Ÿ',
+}
+
+func newCharset(name string, high func(b byte) rune) *Charset {
+	cs := &Charset{Name: name}
+	for i := range cs.high {
+		cs.high[i] = high(byte(i + 0x80))
+	}
+	return cs
+}
+
+// charsets maps normalized encoding labels to a Charset
+var charsets = map[string]*Charset{
+	"us-ascii":       USASCII,
+	"ascii":          USASCII,
+	"ansi_x3.4-1968": USASCII,
+
+	"iso-8859-1":      ISO88591,
+	"iso8859-1":       ISO88591,
+	"iso_8859-1":      ISO88591,
+	"iso_8859-1:1987": ISO88591,
+	"iso-ir-100":      ISO88591,
+	"csisolatin1":     ISO88591,
+	"latin1":          ISO88591,
+	"l1":              ISO88591,
+	"ibm819":          ISO88591,
+	"cp819":           ISO88591,
+
+	"iso-8859-15": ISO885915,
+	"iso8859-15":  ISO885915,
+	"iso_8859-15": ISO885915,
+	"latin9":      ISO885915,
+	"latin-9":     ISO885915,
+
+	"windows-1252": Windows1252,
+	"windows1252":  Windows1252,
+	"cp1252":       Windows1252,
+	"x-cp1252":     Windows1252,
+}
+
+// LookupCharset returns the Charset for an encoding label, ignoring
+// case, or nil if the encoding is not supported.
+func LookupCharset(label string) *Charset {
+	return charsets[strings.ToLower(strings.TrimSpace(label))]
+}
+
+// EncodeError reports a character that cannot be represented in the
+// output Charset where a character reference is not allowed, such as
+// in a name or a comment
+type EncodeError struct {
+	Rune    rune
+	Charset string
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("character %U cannot be encoded in %s", e.Rune, e.Charset)
+}
+
+// charsetWriter encodes UTF-8 text in a Charset.  If refs is set,
+// characters that cannot be represented are written as numeric
+// character references; otherwise they are an *EncodeError.
+type charsetWriter struct {
+	w    *bufio.Writer
+	cs   *Charset
+	refs bool
+	ref  []byte
+}
+
+func (cw *charsetWriter) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		// pass runs of ASCII through unchanged
+		i := n
+		for i < len(p) && p[i] < utf8.RuneSelf {
+			i++
+		}
+		if i > n {
+			if _, err = cw.w.Write(p[n:i]); err != nil {
+				return
+			}
+			n = i
+			continue
+		}
+
+		r, width := utf8.DecodeRune(p[n:])
+		if err = cw.writeRune(r); err != nil {
+			return
+		}
+		n += width
+	}
+	return
+}
+
+func (cw *charsetWriter) WriteString(s string) (n int, err error) {
+	for n < len(s) {
+		i := n
+		for i < len(s) && s[i] < utf8.RuneSelf {
+			i++
+		}
+		if i > n {
+			if _, err = cw.w.WriteString(s[n:i]); err != nil {
+				return
+			}
+			n = i
+			continue
+		}
+
+		r, width := utf8.DecodeRuneInString(s[n:])
+		if err = cw.writeRune(r); err != nil {
+			return
+		}
+		n += width
+	}
+	return
+}
+
+func (cw *charsetWriter) writeRune(r rune) error {
+	if b, ok := cw.cs.Encode(r); ok {
+		return cw.w.WriteByte(b)
+	}
+	if !cw.refs {
+		return &EncodeError{Rune: r, Charset: cw.cs.Name}
+	}
+	cw.ref = append(cw.ref[:0], "&#"...)
+	cw.ref = strconv.AppendInt(cw.ref, int64(r), 10)
+	cw.ref = append(cw.ref, ';')
+	_, err := cw.w.Write(cw.ref)
+	return err
+}
+
+// CharsetReader returns a reader that converts input from the named
+// encoding to UTF-8, and may be used as the CharsetReader of an
+// xml.Decoder.  The encodings known to LookupCharset are supported,
+// along with UTF-8 and UTF-16.
+//
+// A document in UTF-16 cannot be read by an xml.Decoder until it
+// has been converted to UTF-8, and so by the time the Decoder reads
+// the encoding declaration there is nothing left to convert: the
+// UTF-16 labels return input as is.  TransformWithOptions detects
+// and converts UTF-16 input before decoding; NewUTF8Reader may be
+// used to do the same for an xml.Decoder created by the caller.
+func CharsetReader(label string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(label)) {
+	case "utf-8", "utf8", "utf-16", "utf16", "utf-16le", "utf-16be":
+		return input, nil
+	}
+	cs := LookupCharset(label)
+	if cs == nil {
+		return nil, fmt.Errorf("unsupported charset: %s", label)
+	}
+	return &charsetReader{r: input, cs: cs}, nil
+}
+
+// charsetReader decodes a single-byte charset to UTF-8
+type charsetReader struct {
+	r   io.Reader
+	cs  *Charset
+	in  []byte
+	buf []byte
+	out []byte // decoded UTF-8 not yet returned
+	err error
+}
+
+func (cr *charsetReader) Read(p []byte) (n int, err error) {
+	for len(cr.out) == 0 {
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		if cr.in == nil {
+			cr.in = make([]byte, 4096)
+		}
+		var m int
+		m, cr.err = cr.r.Read(cr.in)
+		cr.buf = cr.buf[:0]
+		for _, b := range cr.in[:m] {
+			if b < utf8.RuneSelf {
+				cr.buf = append(cr.buf, b)
+			} else {
+				cr.buf = utf8.AppendRune(cr.buf, cr.cs.Decode(b))
+			}
+		}
+		cr.out = cr.buf
+	}
+	n = copy(p, cr.out)
+	cr.out = cr.out[n:]
+	return
+}
+
+// NewUTF8Reader detects the encoding of an XML document from its
+// byte order mark or, in the absence of a byte order mark, from the
+// way its first characters are encoded (XML 1.0 Appendix F).  Input in
+// UTF-16 is converted to UTF-8, and a UTF-8 byte order mark is
+// removed.  The converted result is reported by utf16.
+func NewUTF8Reader(r io.Reader) (_ io.Reader, utf16 bool) {
+	br := bufio.NewReader(r)
+	b, _ := br.Peek(4)
+	switch {
+	case len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF:
+		br.Discard(2)
+		return &utf16Reader{r: br, bigEndian: true}, true
+	case len(b) >= 2 && b[0] == 0xFF && b[1] == 0xFE:
+		br.Discard(2)
+		return &utf16Reader{r: br}, true
+	case len(b) >= 3 && b[0] == 0xEF && b[1] == 0xBB && b[2] == 0xBF:
+		br.Discard(3)
+	case len(b) == 4 && b[0] == 0 && b[1] == '<' && b[2] == 0 && b[3] == '?':
+		return &utf16Reader{r: br, bigEndian: true}, true
+	case len(b) == 4 && b[0] == '<' && b[1] == 0 && b[2] == '?' && b[3] == 0:
+		return &utf16Reader{r: br}, true
+	}
+	return br, false
+}
+
+// utf16Reader decodes UTF-16 to UTF-8
+type utf16Reader struct {
+	r         io.Reader
+	bigEndian bool
+	in        []byte
+	odd       []byte // a trailing partial code unit
+	high      rune   // a high surrogate awaiting its pair
+	buf       []byte
+	out       []byte
+	err       error
+}
+
+func (ur *utf16Reader) Read(p []byte) (n int, err error) {
+	for len(ur.out) == 0 {
+		if ur.err != nil {
+			if ur.err == io.EOF && (len(ur.odd) > 0 || ur.high != 0) {
+				ur.odd, ur.high = nil, 0
+				ur.out = utf8.AppendRune(ur.buf[:0], utf8.RuneError)
+				continue
+			}
+			return 0, ur.err
+		}
+		if ur.in == nil {
+			ur.in = make([]byte, 4096)
+		}
+		var m int
+		m, ur.err = ur.r.Read(ur.in[len(ur.odd):])
+		copy(ur.in, ur.odd)
+		ur.buf = ur.buf[:0]
+		ur.decode(ur.in[:len(ur.odd)+m])
+		ur.out = ur.buf
+	}
+	n = copy(p, ur.out)
+	ur.out = ur.out[n:]
+	return
+}
+
+func (ur *utf16Reader) decode(b []byte) {
+	for ; len(b) >= 2; b = b[2:] {
+		var u rune
+		if ur.bigEndian {
+			u = rune(b[0])<<8 | rune(b[1])
+		} else {
+			u = rune(b[1])<<8 | rune(b[0])
+		}
+		switch {
+		case u >= 0xD800 && u < 0xDC00:
+			if ur.high != 0 {
+				ur.buf = utf8.AppendRune(ur.buf, utf8.RuneError)
+			}
+			ur.high = u
+			continue
+		case u >= 0xDC00 && u < 0xE000:
+			if ur.high == 0 {
+				u = utf8.RuneError
+			} else {
+				u = 0x10000 + (ur.high-0xD800)<<10 + (u - 0xDC00)
+			}
+		default:
+			if ur.high != 0 {
+				ur.buf = utf8.AppendRune(ur.buf, utf8.RuneError)
+			}
+		}
+		ur.high = 0
+		ur.buf = utf8.AppendRune(ur.buf, u)
+	}
+	ur.odd = append(ur.odd[:0], b...)
+}
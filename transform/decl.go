@@ -0,0 +1,154 @@
+package transform
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+)
+
+// DeclMode controls the handling of the XML declaration by an
+// IdentityTransform.  Output in a Charset other than US-ASCII is not
+// UTF-8, and so always starts with a declaration stating its
+// encoding: DeclPreserve and DeclRewrite are treated as DeclForce,
+// and DeclStrip is an error.  With US-ASCII output, DeclPreserve is
+// treated as DeclRewrite.
+type DeclMode int
+
+const (
+	// DeclPreserve writes the XML declaration of the input, if
+	// any, as is
+	DeclPreserve DeclMode = iota
+
+	// DeclStrip removes the XML declaration
+	DeclStrip
+
+	// DeclRewrite replaces the XML declaration of the input, if
+	// any, with one stating the encoding of the output
+	DeclRewrite
+
+	// DeclForce is like DeclRewrite, but also writes an XML
+	// declaration if the input has none
+	DeclForce
+)
+
+// ErrDeclRequired is returned, wrapped in a *WriteError, by an
+// IdentityTransform that is to strip the XML declaration from output
+// that is not UTF-8
+var ErrDeclRequired = errors.New("XML declaration required by the output charset")
+
+var startDecl = []byte(`<?xml version="`)
+var declEncoding = []byte(`" encoding="`)
+var declStandalone = []byte(`" standalone="`)
+var endDecl = []byte(`"?>`)
+
+// declMode returns the handling of the XML declaration in effect for
+// the output charset
+func (t *IdentityTransform) declMode() DeclMode {
+	switch {
+	case t.Charset == nil:
+		return t.Declaration
+	case t.Charset != USASCII:
+		if t.Declaration == DeclStrip {
+			return DeclStrip
+		}
+		return DeclForce
+	case t.Declaration == DeclPreserve:
+		return DeclRewrite
+	}
+	return t.Declaration
+}
+
+// checkDecl records ErrDeclRequired if the declaration is to be
+// stripped from output that is not UTF-8
+func (t *IdentityTransform) checkDecl() {
+	if t.Declaration == DeclStrip && t.Charset != nil && t.Charset != USASCII {
+		t.fail(ErrDeclRequired)
+	}
+}
+
+// begin is called before writing anything other than an XML
+// declaration, and writes a declaration if one is being forced.
+func (t *IdentityTransform) begin() {
+	if t.prolog {
+		return
+	}
+	t.prolog = true
+	t.checkDecl()
+	if t.declMode() == DeclForce {
+		t.indent()
+		t.writeDecl("", "")
+		if t.Indent == "" {
+			t.write(newline)
+		}
+	}
+}
+
+// declaration handles an XML declaration when it is to be stripped
+// or rewritten.  A declaration that does not start the document is
+// dropped.
+func (t *IdentityTransform) declaration(node xml.ProcInst) error {
+	if !t.prolog {
+		t.checkDecl()
+	}
+	if t.prolog || t.declMode() == DeclStrip {
+		return t.err
+	}
+	t.prolog = true
+	t.indent()
+	t.writeDecl(procInstParam(node.Inst, "version"), procInstParam(node.Inst, "standalone"))
+	return t.err
+}
+
+// writeDecl writes an XML declaration with the given version and
+// standalone values, unless overridden by DeclVersion and
+// DeclStandalone, and the encoding of the output.
+func (t *IdentityTransform) writeDecl(version, standalone string) {
+	if t.DeclVersion != "" {
+		version = t.DeclVersion
+	} else if version == "" {
+		version = "1.0"
+	}
+	if t.DeclStandalone != "" {
+		standalone = t.DeclStandalone
+	}
+	encoding := "UTF-8"
+	if t.Charset != nil {
+		encoding = t.Charset.Name
+	}
+
+	t.write(startDecl)
+	t.writeString(version)
+	t.write(declEncoding)
+	t.writeString(encoding)
+	if standalone != "" {
+		t.write(declStandalone)
+		t.writeString(standalone)
+	}
+	t.write(endDecl)
+}
+
+// procInstParam returns the value of the pseudo-attribute param in
+// the processing instruction data inst, or the empty string.
+func procInstParam(inst []byte, param string) string {
+	s := string(inst)
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return ""
+		}
+		name := strings.TrimRight(s[:eq], " \t\r\n")
+		s = strings.TrimLeft(s[eq+1:], " \t\r\n")
+		if s == "" || (s[0] != '"' && s[0] != '\'') {
+			return ""
+		}
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return ""
+		}
+		if name == param {
+			return s[1 : end+1]
+		}
+		s = s[end+2:]
+	}
+}
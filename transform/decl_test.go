@@ -0,0 +1,153 @@
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type declTest struct {
+	mode       DeclMode
+	charset    *Charset
+	standalone string
+	input      string
+	output     string
+}
+
+var declTests = []declTest{
+	{
+		DeclPreserve, nil, "",
+		`<?xml version='1.0' encoding='utf-8'?>` + "\n<a/>",
+		`<?xml version='1.0' encoding='utf-8'?>` + "\n<a></a>",
+	},
+	{
+		DeclStrip, nil, "",
+		`<?xml version="1.0" encoding="UTF-8"?><?pi x?><a/>`,
+		`<?pi x?><a></a>`,
+	},
+	{
+		DeclRewrite, nil, "",
+		`<?xml version='1.0' encoding='utf-8' standalone='yes'?>` + "\n<a/>",
+		`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n<a></a>",
+	},
+	{
+		DeclRewrite, nil, "",
+		`<a/>`,
+		`<a></a>`,
+	},
+	{
+		DeclForce, ISO88591, "no",
+		`<a/>`,
+		`<?xml version="1.0" encoding="ISO-8859-1" standalone="no"?>` + "\n<a></a>",
+	},
+	{
+		DeclForce, ISO88591, "",
+		`<?xml version="1.0"?><a b="é€">é€</a>`,
+		"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><a b='\xe9&#8364;'>\xe9&#8364;</a>",
+	},
+	{
+		DeclForce, Windows1252, "",
+		`<a b="é€">é€ &#x1F600;</a>`,
+		"<?xml version=\"1.0\" encoding=\"windows-1252\"?>\n<a b='\xe9\x80'>\xe9\x80 &#128512;</a>",
+	},
+}
+
+var charsetDeclTests = []declTest{
+	{
+		DeclPreserve, ISO88591, "",
+		`<?xml version="1.0" encoding="UTF-8"?><a>é</a>`,
+		"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><a>\xe9</a>",
+	},
+	{
+		DeclPreserve, ISO88591, "",
+		`<a>é</a>`,
+		"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<a>\xe9</a>",
+	},
+	{
+		DeclRewrite, Windows1252, "",
+		`<a>é</a>`,
+		"<?xml version=\"1.0\" encoding=\"windows-1252\"?>\n<a>\xe9</a>",
+	},
+	{
+		DeclPreserve, USASCII, "",
+		`<?xml version='1.0' encoding='utf-8'?><a>é</a>`,
+		`<?xml version="1.0" encoding="US-ASCII"?><a>&#233;</a>`,
+	},
+	{
+		DeclStrip, USASCII, "",
+		`<?xml version='1.0' encoding='utf-8'?><a>é</a>`,
+		`<a>&#233;</a>`,
+	},
+}
+
+func TestIdentityDeclaration(t *testing.T) {
+	for i, v := range append(declTests, charsetDeclTests...) {
+		w := new(bytes.Buffer)
+		h := NewIdentityTransform(w)
+		h.Declaration = v.mode
+		h.Charset = v.charset
+		h.DeclStandalone = v.standalone
+		if err := Transform(strings.NewReader(v.input), h); err != nil {
+			t.Fatal(i, err)
+		}
+		if w.String() != v.output {
+			t.Errorf("%d: expected %q, got %q", i, v.output, w.String())
+		}
+	}
+}
+
+func TestLookupCharset(t *testing.T) {
+	for label, cs := range map[string]*Charset{
+		"ISO-8859-1":   ISO88591,
+		" latin1 ":     ISO88591,
+		"Windows-1252": Windows1252,
+		"iso-8859-15":  ISO885915,
+		"US-ASCII":     USASCII,
+		"utf-8":        nil,
+	} {
+		if LookupCharset(label) != cs {
+			t.Errorf("%s: expected %v, got %v", label, cs, LookupCharset(label))
+		}
+	}
+
+	for _, cs := range []*Charset{USASCII, ISO88591, ISO885915, Windows1252} {
+		for i := 0; i < 256; i++ {
+			r := cs.Decode(byte(i))
+			if b, ok := cs.Encode(r); ok && b != byte(i) {
+				t.Errorf("%s: %x decodes to %U, which encodes to %x", cs.Name, i, r, b)
+			}
+		}
+	}
+}
+
+func TestIdentityCharsetErrors(t *testing.T) {
+	tests := []struct {
+		mode  DeclMode
+		input string
+		err   interface{}
+	}{
+		{DeclStrip, `<a>é</a>`, ErrDeclRequired},
+		{DeclForce, `<名/>`, new(*EncodeError)},
+		{DeclForce, `<a 名="x"/>`, new(*EncodeError)},
+		{DeclForce, `<a><!--名--></a>`, new(*EncodeError)},
+		{DeclForce, `<a><?pi 名?></a>`, new(*EncodeError)},
+	}
+	for _, v := range tests {
+		h := NewIdentityTransform(new(bytes.Buffer))
+		h.Declaration = v.mode
+		h.Charset = ISO88591
+		err := Transform(strings.NewReader(v.input), h)
+		var werr *WriteError
+		if !errors.As(err, &werr) {
+			t.Errorf("%s: expected a *WriteError, got %T", v.input, err)
+		}
+		if target, ok := v.err.(error); ok {
+			if !errors.Is(err, target) {
+				t.Errorf("%s: expected %v, got %v", v.input, target, err)
+			}
+		} else if !errors.As(err, v.err) {
+			t.Errorf("%s: expected an EncodeError, got %v", v.input, err)
+		}
+	}
+}
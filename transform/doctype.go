@@ -0,0 +1,487 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Doctype describes a document type declaration
+type Doctype struct {
+	// Name is the name of the root element
+	Name string
+
+	// PublicID and SystemID identify the external subset of the
+	// DTD, if any
+	PublicID string
+	SystemID string
+
+	// InternalSubset is the text of the internal subset of the
+	// DTD, between the square brackets, if any
+	InternalSubset string
+
+	// Entities maps the names of the internal general entities
+	// declared in the internal subset to their replacement text
+	Entities map[string]string
+}
+
+// EntityResolver loads external entities, such as the external
+// subset of a DTD, given their public and system identifiers.  The
+// system identifier is passed as written in the document.
+type EntityResolver interface {
+	ResolveEntity(publicID, systemID string) (io.ReadCloser, error)
+}
+
+// ErrNotDoctype is returned by ParseDoctype for a directive that is
+// not a document type declaration
+var ErrNotDoctype = errors.New("not a document type declaration")
+
+// maxEntitySize limits the replacement text of an entity, guarding
+// against the exponential expansion of nested entity references
+const maxEntitySize = 1 << 20
+
+// maxDTDSize limits the size of an external DTD subset
+const maxDTDSize = 1 << 22
+
+// ParseDoctype parses dir, the content of a <!DOCTYPE ...> directive
+// as reported by the decoder.  A directive other than a DOCTYPE
+// results in ErrNotDoctype.
+func ParseDoctype(dir xml.Directive) (*Doctype, error) {
+	return parseDoctype(dir, &expansion{max: defaultMaxEntityExpansion})
+}
+
+// parseDoctype is like ParseDoctype, charging the replacement text of
+// the entities declared to exp
+func parseDoctype(dir xml.Directive, exp *expansion) (*Doctype, error) {
+	s := &dtdScanner{b: dir}
+	if !s.consume("DOCTYPE") || !s.space() {
+		return nil, ErrNotDoctype
+	}
+
+	d := &Doctype{Entities: make(map[string]string)}
+	if d.Name = s.name(); d.Name == "" {
+		return nil, s.errorf("expected root element name")
+	}
+	s.space()
+
+	var err error
+	switch {
+	case s.consume("SYSTEM"):
+		s.space()
+		if d.SystemID, err = s.literal(); err != nil {
+			return nil, err
+		}
+	case s.consume("PUBLIC"):
+		s.space()
+		if d.PublicID, err = s.literal(); err != nil {
+			return nil, err
+		}
+		s.space()
+		if d.SystemID, err = s.literal(); err != nil {
+			return nil, err
+		}
+	}
+	s.space()
+
+	if s.consume("[") {
+		start := s.i
+		end := s.subsetEnd()
+		if end < 0 {
+			return nil, s.errorf("unterminated internal subset")
+		}
+		d.InternalSubset = string(s.b[start:end])
+		s.i = end + 1
+		s.space()
+	}
+	if s.i < len(s.b) {
+		return nil, s.errorf("unexpected %q", s.b[s.i:])
+	}
+
+	return d, parseEntities([]byte(d.InternalSubset), d.Entities, exp)
+}
+
+// ParseEntities adds the internal general entities declared in dtd,
+// the text of an internal or external DTD subset, to entities.  An
+// entity already in entities is not redeclared, as the first
+// declaration of an entity is binding.
+//
+// Character references, and references to the predefined entities
+// and to entities already declared, are expanded in the replacement
+// text.  Parameter entities are not supported: their declarations
+// and references are skipped, as are conditional sections.
+//
+// The replacement text of each entity is limited to 1 MiB, and the
+// total declared by a call to 64 MiB, guarding against the
+// exponential expansion of nested entity references.  The total
+// limit is enforced with a *LimitError.
+func ParseEntities(dtd []byte, entities map[string]string) error {
+	return parseEntities(dtd, entities, &expansion{max: defaultMaxEntityExpansion})
+}
+
+// parseEntities is like ParseEntities, charging the replacement text
+// of the entities declared to exp
+func parseEntities(dtd []byte, entities map[string]string, exp *expansion) error {
+	s := &dtdScanner{b: dtd, exp: exp}
+	for s.space(); s.i < len(s.b); s.space() {
+		var err error
+		switch {
+		case s.consume("<!--"):
+			err = s.skipPast("-->")
+		case s.consume("<?"):
+			err = s.skipPast("?>")
+		case s.consume("<!["):
+			err = s.skipPast("]]>")
+		case s.consume("<!ENTITY"):
+			err = s.entity(entities)
+		case s.consume("<!"):
+			err = s.skipDecl()
+		case s.b[s.i] == '%':
+			err = s.skipPast(";")
+		default:
+			err = s.errorf("unexpected %q", s.b[s.i:])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dtdScanner scans the text of a DTD
+type dtdScanner struct {
+	b   []byte
+	i   int
+	exp *expansion // charged with the replacement text declared
+}
+
+func (s *dtdScanner) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("doctype: offset %d: %s", s.i, fmt.Sprintf(format, args...))
+}
+
+// space skips whitespace, reporting whether there was any
+func (s *dtdScanner) space() bool {
+	start := s.i
+	for s.i < len(s.b) && isSpace(s.b[s.i:s.i+1]) {
+		s.i++
+	}
+	return s.i > start
+}
+
+// consume skips prefix, if it is next
+func (s *dtdScanner) consume(prefix string) bool {
+	if bytes.HasPrefix(s.b[s.i:], []byte(prefix)) {
+		s.i += len(prefix)
+		return true
+	}
+	return false
+}
+
+// skipPast skips up to and including the next occurrence of end
+func (s *dtdScanner) skipPast(end string) error {
+	i := bytes.Index(s.b[s.i:], []byte(end))
+	if i < 0 {
+		return s.errorf("expected %q", end)
+	}
+	s.i += i + len(end)
+	return nil
+}
+
+// skipDecl skips the rest of a markup declaration
+func (s *dtdScanner) skipDecl() error {
+	var quote byte
+	for ; s.i < len(s.b); s.i++ {
+		switch c := s.b[s.i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			s.i++
+			return nil
+		}
+	}
+	return s.errorf("unterminated declaration")
+}
+
+// subsetEnd returns the index of the ']' ending an internal subset,
+// or -1
+func (s *dtdScanner) subsetEnd() int {
+	var quote byte
+	for i := s.i; i < len(s.b); i++ {
+		switch c := s.b[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '<' && bytes.HasPrefix(s.b[i:], []byte("<?")):
+			end := bytes.Index(s.b[i:], []byte("?>"))
+			if end < 0 {
+				return -1
+			}
+			i += end + 1
+		case c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *dtdScanner) name() string {
+	start := s.i
+	for s.i < len(s.b) {
+		r, n := utf8.DecodeRune(s.b[s.i:])
+		if !isNameChar(r) {
+			break
+		}
+		s.i += n
+	}
+	return string(s.b[start:s.i])
+}
+
+func isNameChar(r rune) bool {
+	return r >= utf8.RuneSelf && r != utf8.RuneError ||
+		'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' ||
+		r == '_' || r == ':' || r == '-' || r == '.'
+}
+
+// literal returns the content of a quoted literal
+func (s *dtdScanner) literal() (string, error) {
+	if s.i >= len(s.b) || (s.b[s.i] != '"' && s.b[s.i] != '\'') {
+		return "", s.errorf("expected quoted literal")
+	}
+	quote := s.b[s.i]
+	end := bytes.IndexByte(s.b[s.i+1:], quote)
+	if end < 0 {
+		return "", s.errorf("unterminated literal")
+	}
+	lit := string(s.b[s.i+1 : s.i+1+end])
+	s.i += end + 2
+	return lit, nil
+}
+
+// entity parses the rest of an entity declaration
+func (s *dtdScanner) entity(entities map[string]string) error {
+	if !s.space() {
+		return s.errorf("expected whitespace after <!ENTITY")
+	}
+	if s.consume("%") {
+		return s.skipDecl()
+	}
+	name := s.name()
+	if name == "" {
+		return s.errorf("expected entity name")
+	}
+	s.space()
+	if s.i >= len(s.b) || (s.b[s.i] != '"' && s.b[s.i] != '\'') {
+		// an external entity
+		return s.skipDecl()
+	}
+
+	lit, err := s.literal()
+	if err != nil {
+		return err
+	}
+	s.space()
+	if !s.consume(">") {
+		return s.errorf("expected > after entity value")
+	}
+	if _, ok := entities[name]; ok {
+		return nil
+	}
+	value, err := expandEntityValue(lit, entities, s.exp)
+	if err != nil {
+		return fmt.Errorf("doctype: entity %s: %w", name, err)
+	}
+	entities[name] = value
+	return nil
+}
+
+var predefinedEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"apos": "'",
+	"quot": `"`,
+}
+
+// expandEntityValue expands the character and entity references in
+// an entity value, charging the replacement text to exp as it grows.
+// References to undeclared entities are kept.
+func expandEntityValue(lit string, entities map[string]string, exp *expansion) (string, error) {
+	var b []byte
+	var charged int
+	for len(lit) > 0 {
+		amp := strings.IndexByte(lit, '&')
+		if amp < 0 {
+			b = append(b, lit...)
+			break
+		}
+		b = append(b, lit[:amp]...)
+		lit = lit[amp:]
+		semi := strings.IndexByte(lit, ';')
+		if semi < 0 {
+			b = append(b, lit...)
+			break
+		}
+		ref := lit[1:semi]
+		lit = lit[semi+1:]
+
+		if len(ref) > 1 && ref[0] == '#' {
+			var n uint64
+			var err error
+			if ref[1] == 'x' {
+				n, err = strconv.ParseUint(ref[2:], 16, 32)
+			} else {
+				n, err = strconv.ParseUint(ref[1:], 10, 32)
+			}
+			if err != nil || !isInCharacterRange(rune(n)) {
+				return "", fmt.Errorf("invalid character reference &%s;", ref)
+			}
+			b = utf8.AppendRune(b, rune(n))
+		} else if v, ok := predefinedEntities[ref]; ok {
+			b = append(b, v...)
+		} else if v, ok := entities[ref]; ok {
+			b = append(b, v...)
+		} else {
+			b = append(b, '&')
+			b = append(b, ref...)
+			b = append(b, ';')
+		}
+		if len(b) > maxEntitySize {
+			return "", fmt.Errorf("replacement text exceeds %d bytes", maxEntitySize)
+		}
+		if err := exp.charge(int64(len(b) - charged)); err != nil {
+			return "", err
+		}
+		charged = len(b)
+	}
+	return string(b), exp.charge(int64(len(b) - charged))
+}
+
+// expansion charges replacement text against a limit: that of the
+// entities declared by a DTD, and that of the entity references read
+// by the decoder.  As the decoder reads its input one byte at a time,
+// a reference that would exceed the limit fails before the decoder
+// expands it.
+type expansion struct {
+	entities map[string]string
+	longest  int // length of the longest entity name
+	max      int64
+	n        int64  // bytes of replacement text charged
+	ref      []byte // name of the reference being read
+	inRef    bool
+}
+
+// declare sets the entities whose references are charged
+func (e *expansion) declare(entities map[string]string) {
+	e.entities = entities
+	e.longest = 0
+	for name := range entities {
+		if len(name) > e.longest {
+			e.longest = len(name)
+		}
+	}
+}
+
+// charge adds n bytes of replacement text
+func (e *expansion) charge(n int64) error {
+	e.n += n
+	if e.n > e.max {
+		return &LimitError{Limit: LimitEntityExpansion, Max: e.max}
+	}
+	return nil
+}
+
+// scan examines the next byte of input
+func (e *expansion) scan(b byte) error {
+	switch {
+	case b == '&':
+		e.inRef = true
+		e.ref = e.ref[:0]
+	case !e.inRef:
+	case b == ';':
+		e.inRef = false
+		if v, ok := e.entities[string(e.ref)]; ok {
+			return e.charge(int64(len(v)))
+		}
+	case len(e.ref) < e.longest:
+		e.ref = append(e.ref, b)
+	default:
+		// longer than any entity name
+		e.inRef = false
+	}
+	return nil
+}
+
+// doctype adds the entities declared by a DOCTYPE directive to the
+// entity map of the decoder, when ExpandEntities is set.  Errors are
+// passed to the handler, and only an error that stops the transform
+// is returned.
+func (t *transformer) doctype(tok xml.Token) error {
+	dir, ok := tok.(xml.Directive)
+	if !ok || !t.opts.ExpandEntities {
+		return nil
+	}
+	err := t.declare(dir)
+	var lerr *LimitError
+	switch {
+	case err == nil || err == ErrNotDoctype:
+	case errors.As(err, &lerr):
+		return t.wrap(err)
+	default:
+		return t.recover(t.wrap(err), true)
+	}
+	return nil
+}
+
+func (t *transformer) declare(dir xml.Directive) error {
+	doc, err := parseDoctype(dir, t.input.exp)
+	if doc == nil {
+		return err
+	}
+
+	entities := make(map[string]string, len(doc.Entities)+len(t.opts.Entity))
+	for k, v := range doc.Entities {
+		entities[k] = v
+	}
+	if t.opts.EntityResolver != nil && doc.SystemID != "" && err == nil {
+		err = t.external(doc, entities)
+	}
+	for k, v := range t.opts.Entity {
+		if _, ok := entities[k]; !ok {
+			entities[k] = v
+		}
+	}
+	t.entity = entities
+	t.dec.Entity = entities
+	t.input.exp.declare(entities)
+	return err
+}
+
+// external adds the entities declared in the external subset of the
+// DTD to entities
+func (t *transformer) external(doc *Doctype, entities map[string]string) error {
+	rc, err := t.opts.EntityResolver.ResolveEntity(doc.PublicID, doc.SystemID)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dtd, err := io.ReadAll(io.LimitReader(rc, maxDTDSize+1))
+	if err != nil {
+		return err
+	}
+	if len(dtd) > maxDTDSize {
+		return fmt.Errorf("doctype: external subset %s exceeds %d bytes", doc.SystemID, maxDTDSize)
+	}
+	return parseEntities(dtd, entities, t.input.exp)
+}
@@ -0,0 +1,252 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type doctypeTest struct {
+	descr   string
+	input   string
+	doctype *Doctype
+	err     bool
+}
+
+var doctypeTests = []doctypeTest{
+	{
+		"Name only",
+		`DOCTYPE html`,
+		&Doctype{Name: "html", Entities: map[string]string{}},
+		false,
+	},
+	{
+		"Public",
+		`DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" 'http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd'`,
+		&Doctype{
+			Name:     "html",
+			PublicID: "-//W3C//DTD XHTML 1.0 Strict//EN",
+			SystemID: "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd",
+			Entities: map[string]string{},
+		},
+		false,
+	},
+	{
+		"Internal subset",
+		`DOCTYPE doc SYSTEM "doc.dtd" [
+  <!ELEMENT doc (#PCDATA)>
+  <!ATTLIST doc a CDATA "x>y">
+  <!ENTITY % pe "<!ENTITY hidden 'no'>">
+  %pe;
+  <?pi ]]?>
+  <!ENTITY company "ACME &amp; Sons">
+  <!ENTITY copy '&#169; &company;'>
+  <!ENTITY company "ignored">
+  <!ENTITY logo SYSTEM "logo.gif" NDATA gif>
+  <!ENTITY unknown "&undeclared;">
+]`,
+		&Doctype{
+			Name:     "doc",
+			SystemID: "doc.dtd",
+			InternalSubset: `
+  <!ELEMENT doc (#PCDATA)>
+  <!ATTLIST doc a CDATA "x>y">
+  <!ENTITY % pe "<!ENTITY hidden 'no'>">
+  %pe;
+  <?pi ]]?>
+  <!ENTITY company "ACME &amp; Sons">
+  <!ENTITY copy '&#169; &company;'>
+  <!ENTITY company "ignored">
+  <!ENTITY logo SYSTEM "logo.gif" NDATA gif>
+  <!ENTITY unknown "&undeclared;">
+`,
+			Entities: map[string]string{
+				"company": "ACME & Sons",
+				"copy":    "© ACME & Sons",
+				"unknown": "&undeclared;",
+			},
+		},
+		false,
+	},
+	{
+		"Not a doctype",
+		`ENTITY x "y"`,
+		nil,
+		true,
+	},
+	{
+		"Missing system literal",
+		`DOCTYPE doc PUBLIC "pub"`,
+		nil,
+		true,
+	},
+}
+
+func TestParseDoctype(t *testing.T) {
+	for _, v := range doctypeTests {
+		d, err := ParseDoctype(xml.Directive(v.input))
+		if v.err != (err != nil) {
+			t.Errorf("%s: unexpected error %v", v.descr, err)
+			continue
+		}
+		if v.doctype != nil && !reflect.DeepEqual(d, v.doctype) {
+			t.Errorf("%s: expected %+v, got %+v", v.descr, v.doctype, d)
+		}
+	}
+}
+
+// mapResolver resolves system identifiers from a map
+type mapResolver map[string]string
+
+func (r mapResolver) ResolveEntity(publicID, systemID string) (io.ReadCloser, error) {
+	dtd, ok := r[systemID]
+	if !ok {
+		return nil, errors.New("not found: " + systemID)
+	}
+	return io.NopCloser(strings.NewReader(dtd)), nil
+}
+
+type expandTest struct {
+	descr  string
+	opts   *TransformOptions
+	input  string
+	output string
+	err    bool
+}
+
+var expandTests = []expandTest{
+	{
+		"Entities not expanded",
+		NewTransformOptions(),
+		`<!DOCTYPE a [<!ENTITY e "x">]><a>&e;</a>`,
+		``,
+		true,
+	},
+	{
+		"Internal subset",
+		&TransformOptions{Strict: true, ExpandEntities: true},
+		`<!DOCTYPE a [<!ENTITY e "x &lt; y">]><a b="&e;">&e;</a>`,
+		`<!DOCTYPE a [<!ENTITY e "x &lt; y">]><a b='x &lt; y'>x &lt; y</a>`,
+		false,
+	},
+	{
+		"External subset",
+		&TransformOptions{
+			Strict:         true,
+			ExpandEntities: true,
+			EntityResolver: mapResolver{"a.dtd": `<?xml version="1.0"?><!ENTITY e "external"><!ENTITY f "f">`},
+		},
+		`<!DOCTYPE a SYSTEM "a.dtd" [<!ENTITY e "internal">]><a>&e; &f;</a>`,
+		`<!DOCTYPE a SYSTEM "a.dtd" [<!ENTITY e "internal">]><a>internal f</a>`,
+		false,
+	},
+	{
+		"External subset not found",
+		&TransformOptions{Strict: true, ExpandEntities: true, EntityResolver: mapResolver{}},
+		`<!DOCTYPE a SYSTEM "a.dtd"><a/>`,
+		``,
+		true,
+	},
+	{
+		"Document entities take precedence",
+		&TransformOptions{Strict: true, ExpandEntities: true, Entity: map[string]string{"e": "option", "f": "f"}},
+		`<!DOCTYPE a [<!ENTITY e "document">]><a>&e; &f;</a>`,
+		`<!DOCTYPE a [<!ENTITY e "document">]><a>document f</a>`,
+		false,
+	},
+	{
+		"Expansion limit",
+		&TransformOptions{Strict: true, ExpandEntities: true},
+		`<!DOCTYPE a [` + entityBomb + `]><a/>`,
+		``,
+		true,
+	},
+}
+
+// entityBomb declares entities whose expansion grows exponentially
+var entityBomb = func() string {
+	var b strings.Builder
+	b.WriteString(`<!ENTITY e0 "0123456789">`)
+	for i := 1; i < 10; i++ {
+		b.WriteString(`<!ENTITY e` + string(rune('0'+i)) + ` "`)
+		for j := 0; j < 10; j++ {
+			b.WriteString(`&e` + string(rune('0'+i-1)) + `;`)
+		}
+		b.WriteString(`">`)
+	}
+	return b.String()
+}()
+
+func TestExpandEntities(t *testing.T) {
+	for _, v := range expandTests {
+		w := new(bytes.Buffer)
+		err := TransformWithOptions(strings.NewReader(v.input), NewIdentityTransform(w), v.opts)
+		if v.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", v.descr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", v.descr, err)
+			continue
+		}
+		if w.String() != v.output {
+			t.Errorf("%s: expected %s, got %s", v.descr, v.output, w.String())
+		}
+	}
+}
+
+func TestEntityExpansionLimit(t *testing.T) {
+	small := `<!DOCTYPE a [<!ENTITY e "0123456789">]><a x="&e;">&e;&e;<!--&e;-->&e;</a>`
+	large := `<!DOCTYPE a [<!ENTITY e "` + strings.Repeat("x", 1<<20-16) + `">]><a>` +
+		strings.Repeat("&e;", 200) + `</a>`
+	var nested strings.Builder
+	nested.WriteString(`<!ENTITY e "` + strings.Repeat("x", 1<<20-16) + `">`)
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&nested, `<!ENTITY e%d "&e;">`, i)
+	}
+
+	tests := []struct {
+		descr string
+		opts  TransformOptions
+		input string
+		err   bool
+	}{
+		{"within limit", TransformOptions{MaxEntityExpansion: 60}, small, false},
+		{"limit exceeded", TransformOptions{MaxEntityExpansion: 59}, small, true},
+		{"limited by MaxBytes", TransformOptions{MaxBytes: 4 << 20, MaxTokenSize: 2 << 20}, large, true},
+		{"default limit", TransformOptions{}, large, true},
+		{"nested declarations", TransformOptions{MaxEntityExpansion: 8 << 20}, `<!DOCTYPE a [` + nested.String() + `]><a/>`, true},
+	}
+	for _, v := range tests {
+		opts := v.opts
+		opts.Strict = true
+		opts.ExpandEntities = true
+		w := new(bytes.Buffer)
+		err := TransformWithOptions(strings.NewReader(v.input), NewIdentityTransform(w), &opts)
+		if !v.err {
+			if err != nil {
+				t.Errorf("%s: %v", v.descr, err)
+			}
+			continue
+		}
+		var lerr *LimitError
+		if !errors.As(err, &lerr) || lerr.Limit != LimitEntityExpansion {
+			t.Errorf("%s: expected an entity expansion *LimitError, got %v", v.descr, err)
+		}
+		if max := opts.maxEntityExpansion() + int64(len(v.input)); int64(w.Len()) > max {
+			t.Errorf("%s: expected at most %d bytes of output, got %d", v.descr, max, w.Len())
+		}
+	}
+
+	var lerr *LimitError
+	if err := ParseEntities([]byte(nested.String()), make(map[string]string)); !errors.As(err, &lerr) {
+		t.Errorf("ParseEntities: expected a *LimitError, got %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package transform
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/xml"
+	"fmt"
 	"github.com/jimrobinson/xml/xmlns"
 	"io"
 )
@@ -9,18 +12,84 @@ import (
 // IdentityTransform implements a Handler that writes serialzed XML
 // that is semantically, but not necessarily syntactically, equivalent
 // to its input.
+//
+// IdentityTransform also implements Emitter, so it may be used as the
+// sink at the end of a chain of TokenHandlers.
+//
+// Output is buffered, and is only guaranteed to have been written to
+// the underlying io.Writer once Flush has been called.  Errors
+// returned by the underlying io.Writer are sticky: once a write has
+// failed, no further output is attempted, and every subsequent
+// handler method and Flush return the error as a *WriteError.
 type IdentityTransform struct {
-	w  io.Writer
-	ns *xmlns.XmlNamespace
+	// SelfClose causes elements without content to be written
+	// using the empty-element tag <x/> rather than <x></x>
+	SelfClose bool
+
+	// Escape controls the quoting of attribute values and the
+	// escaping of attribute values and character data
+	Escape EscapePolicy
+
+	// Indent, if not empty, causes whitespace-only character data
+	// between elements to be discarded and each element to be
+	// written on a new line, indented by one copy of Indent per
+	// level of nesting.  Whitespace is left untouched within the
+	// scope of xml:space="preserve" and within mixed content.
+	// Because the output is streamed, content is only known to be
+	// mixed once non-whitespace text is seen: whitespace between
+	// child elements that precedes the first such text in an element
+	// will already have been reindented.
+	Indent string
+
+	// Charset, if not nil, is the encoding of the output, which is
+	// stated by the XML declaration.  Characters in character data
+	// and attribute values that cannot be represented in Charset
+	// are written as numeric character references; elsewhere they
+	// are a write error.  Output is UTF-8 by default.
+	Charset *Charset
+
+	// Raw, if true, causes names to be written with Name.Space as
+	// the prefix, as reported by xml.Decoder.RawToken, rather than
+	// mapping Name.Space from a namespace uri to a prefix.  Set
+	// Raw when transforming with TransformOptions.RawTokens.
+	Raw bool
+
+	// Declaration controls the handling of the XML declaration,
+	// subject to Charset.  See DeclMode.
+	Declaration DeclMode
+
+	// DeclVersion and DeclStandalone, if not empty, replace the
+	// version and standalone values of a rewritten or forced XML
+	// declaration.  The encoding of the declaration is always that
+	// of the output.
+	DeclVersion    string
+	DeclStandalone string
+
+	w        *bufio.Writer
+	cw       charsetWriter
+	err      error // first *WriteError
+	ns       *xmlns.XmlNamespace
+	pending  bool    // a start tag has been written without its closing '>'
+	levels   []level // open elements
+	ws       []byte  // whitespace held back while indenting
+	started  bool    // output has been written
+	brackets int     // consecutive ']' ending the character data written
+	prolog   bool    // the start of the document has been handled
 }
 
 func NewIdentityTransform(w io.Writer) *IdentityTransform {
 	return &IdentityTransform{
-		w:  w,
+		w:  bufio.NewWriter(w),
 		ns: xmlns.NewXmlNamespace(),
 	}
 }
 
+// Emit serializes tok by passing it to the appropriate handler method
+func (t *IdentityTransform) Emit(tok xml.Token) error {
+	return Dispatch(t, tok)
+}
+
+const xmlPrefix = "xml"
 const xmlnsPrefix = "xmlns"
 const xmlSpace = "http://www.w3.org/XML/1998/namespace"
 
@@ -29,106 +98,271 @@ var xmlnsDecl = []byte("xmlns:")
 
 var startStartElement = []byte("<")
 var endStartElement = []byte(">")
+var endEmptyElement = []byte("/>")
 
 var startAttr = []byte("='")
 var endAttr = []byte("'")
+var startAttrQuot = []byte(`="`)
+var endAttrQuot = []byte(`"`)
 
 var colon = []byte(":")
 var space = []byte(" ")
 
-func (t *IdentityTransform) StartElement(node xml.StartElement) (err error) {
-	t.ns.Push(node)
+// WriteError reports a failure to write output.
+type WriteError struct {
+	// FlushPath is the path of the element being written when the
+	// buffered output was flushed to the underlying io.Writer.
+	// Because of the buffering, the output that failed may belong
+	// to an element that precedes FlushPath in document order.
+	FlushPath string
+	Err       error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("write error flushing %s: %v", e.FlushPath, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// fail records err as the sticky write error
+func (t *IdentityTransform) fail(err error) {
+	if err != nil && t.err == nil {
+		t.err = &WriteError{FlushPath: t.path(), Err: err}
+	}
+}
 
-	t.w.Write(startStartElement)
-	if node.Name.Space != "" {
-		if p := t.ns.Prefix(node.Name.Space); p != "" {
-			t.w.Write([]byte(p))
-			t.w.Write(colon)
+// path returns a slash separated path to the current element
+func (t *IdentityTransform) path() string {
+	if len(t.levels) == 0 {
+		return "/"
+	}
+	var b bytes.Buffer
+	for _, l := range t.levels {
+		b.WriteByte('/')
+		if p := t.prefix(l.name.Space); p != "" {
+			b.WriteString(p)
+			b.WriteByte(':')
 		}
+		b.WriteString(l.name.Local)
+	}
+	return b.String()
+}
+
+// textWriter is implemented by *bufio.Writer and *charsetWriter
+type textWriter interface {
+	io.Writer
+	io.StringWriter
+}
+
+// out returns the writer for the output encoding.  If text is set,
+// the writer is for character data or an attribute value, in which
+// characters outside of Charset are written as character references.
+func (t *IdentityTransform) out(text bool) textWriter {
+	if t.Charset == nil {
+		return t.w
+	}
+	t.cw.w, t.cw.cs, t.cw.refs = t.w, t.Charset, text
+	return &t.cw
+}
+
+func (t *IdentityTransform) write(b []byte) {
+	t.brackets = 0
+	if t.err == nil {
+		_, err := t.out(false).Write(b)
+		t.fail(err)
+	}
+}
+
+func (t *IdentityTransform) writeString(s string) {
+	t.brackets = 0
+	if t.err == nil {
+		_, err := t.out(false).WriteString(s)
+		t.fail(err)
+	}
+}
+
+func (t *IdentityTransform) escape(b []byte, nodeType NodeType) {
+	if t.err == nil {
+		t.fail(t.Escape.escapeText(t.out(true), b, nodeType, &t.brackets))
+	}
+}
+
+func (t *IdentityTransform) escapeString(s string, nodeType NodeType) {
+	if t.err == nil {
+		t.fail(t.Escape.EscapeString(t.out(true), s, nodeType))
+	}
+}
+
+// prefix returns the prefix to write for the Name.Space space
+func (t *IdentityTransform) prefix(space string) string {
+	if t.Raw || space == "" {
+		return space
+	}
+	return t.ns.Prefix(space)
+}
+
+// writeName writes name, prefixed according to the namespace
+// mappings in scope
+func (t *IdentityTransform) writeName(name xml.Name) {
+	if p := t.prefix(name.Space); p != "" {
+		t.writeString(p)
+		t.write(colon)
+	}
+	t.writeString(name.Local)
+}
+
+func (t *IdentityTransform) StartElement(node xml.StartElement) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.begin()
+	t.closeStart()
+	t.indent()
+	t.push(node)
+	t.ns.Push(node)
+
+	t.write(startStartElement)
+	t.writeName(node.Name)
+
+	start, end := startAttr, endAttr
+	if t.Escape.QuoteChar() == '"' {
+		start, end = startAttrQuot, endAttrQuot
 	}
-	t.w.Write([]byte(node.Name.Local))
 	for i := range node.Attr {
 		attr := node.Attr[i]
-		t.w.Write(space)
-		if attr.Name.Space != "" {
-			if attr.Name.Space == xmlSpace {
-				t.w.Write(xmlDecl)
-			} else if attr.Name.Space == xmlnsPrefix {
-				t.w.Write(xmlnsDecl)
-			} else if p := t.ns.Prefix(attr.Name.Space); p != "" {
-				t.w.Write([]byte(p))
-				t.w.Write(colon)
-			}
-		}
-		t.w.Write([]byte(attr.Name.Local))
-		t.w.Write(startAttr)
-		if err = EscapeNodeValue(t.w, []byte(attr.Value), AttrValue); err != nil {
-			return
+		t.write(space)
+		if attr.Name.Space == xmlSpace {
+			t.write(xmlDecl)
+			t.writeString(attr.Name.Local)
+		} else if attr.Name.Space == xmlnsPrefix {
+			t.write(xmlnsDecl)
+			t.writeString(attr.Name.Local)
+		} else {
+			t.writeName(attr.Name)
 		}
-		t.w.Write(endAttr)
+		t.write(start)
+		t.escapeString(attr.Value, AttrValue)
+		t.write(end)
+	}
+	t.pending = true
+	return t.err
+}
+
+// closeStart completes a pending start tag.  The '>' of a start tag
+// is held back until the next event, so that an immediately
+// following EndElement can be written as an empty-element tag.
+func (t *IdentityTransform) closeStart() {
+	if t.pending {
+		t.write(endStartElement)
+		t.pending = false
 	}
-	t.w.Write(endStartElement)
-	return
 }
 
 var startEndElement = []byte("</")
 var endEndElement = []byte(">")
 
-func (t *IdentityTransform) EndElement(node xml.EndElement) (err error) {
-	t.w.Write(startEndElement)
-	if node.Name.Space != "" {
-		if p := t.ns.Prefix(node.Name.Space); p != "" {
-			t.w.Write([]byte(p))
-			t.w.Write(colon)
-		}
+func (t *IdentityTransform) EndElement(node xml.EndElement) error {
+	if t.err != nil {
+		return t.err
+	}
+	defer t.pop()
+
+	t.indentEnd()
+	if t.pending && t.SelfClose {
+		t.write(endEmptyElement)
+		t.pending = false
+		t.ns.Pop()
+		return t.err
 	}
-	t.w.Write([]byte(node.Name.Local))
-	t.w.Write(endEndElement)
+	t.closeStart()
+
+	t.write(startEndElement)
+	t.writeName(node.Name)
+	t.write(endEndElement)
 
 	t.ns.Pop()
-	return
+	return t.err
 }
 
-func (t *IdentityTransform) CharData(node xml.CharData) (err error) {
-	return EscapeNodeValue(t.w, node, CharData)
+func (t *IdentityTransform) CharData(node xml.CharData) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.begin()
+	if t.Indent != "" && t.holdSpace(node) {
+		return t.err
+	}
+	t.closeStart()
+	t.escape(node, CharData)
+	return t.err
 }
 
 var startComment = []byte("<!--")
 var endComment = []byte("-->")
 
-func (t *IdentityTransform) Comment(node xml.Comment) (err error) {
-	t.w.Write(startComment)
-	t.w.Write(node)
-	t.w.Write(endComment)
-	return
+func (t *IdentityTransform) Comment(node xml.Comment) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.begin()
+	t.closeStart()
+	t.indent()
+	t.write(startComment)
+	t.write(node)
+	t.write(endComment)
+	return t.err
 }
 
 var startDirective = []byte("<!")
 var endDirective = []byte(">")
 
-func (t *IdentityTransform) Directive(node xml.Directive) (err error) {
-	t.w.Write(startDirective)
-	t.w.Write(node)
-	t.w.Write(endDirective)
-	return
+func (t *IdentityTransform) Directive(node xml.Directive) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.begin()
+	t.closeStart()
+	t.indent()
+	t.write(startDirective)
+	t.write(node)
+	t.write(endDirective)
+	return t.err
 }
 
 var startProcInst = []byte("<?")
 var endProcInst = []byte("?>")
 
-func (t *IdentityTransform) ProcInst(node xml.ProcInst) (err error) {
-	t.w.Write(startProcInst)
-	t.w.Write([]byte(node.Target))
-	t.w.Write(space)
-	t.w.Write(node.Inst)
-	t.w.Write(endProcInst)
-	return
+func (t *IdentityTransform) ProcInst(node xml.ProcInst) error {
+	if t.err != nil {
+		return t.err
+	}
+	if node.Target == xmlPrefix && t.declMode() != DeclPreserve {
+		return t.declaration(node)
+	}
+	t.begin()
+	t.closeStart()
+	t.indent()
+	t.write(startProcInst)
+	t.writeString(node.Target)
+	t.write(space)
+	t.write(node.Inst)
+	t.write(endProcInst)
+	return t.err
 }
 
 func (t *IdentityTransform) Error(err error) (abort bool) {
 	return true
 }
 
-func (t *IdentityTransform) Flush() (err error) {
-	return nil
+// Flush completes any pending start tag, writes any buffered output
+// to the underlying io.Writer, and returns the first error
+// encountered while writing, if any.
+func (t *IdentityTransform) Flush() error {
+	t.closeStart()
+	if t.err == nil {
+		t.fail(t.w.Flush())
+	}
+	return t.err
 }
@@ -3,6 +3,7 @@ package transform
 import (
 	"bytes"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
@@ -20,7 +21,7 @@ func TestIdentity(t *testing.T) {
 				t.Fatal(err)
 			}
 		} else if err != nil && v.err == nil {
-			t.Error("expected a nil error, got %v", err)
+			t.Errorf("expected a nil error, got %v", err)
 		} else if err == nil && v.err != nil {
 			t.Errorf("expected an error %v got nil", v.err)
 		} else if err.Error() != v.err.Error() {
@@ -29,6 +30,128 @@ func TestIdentity(t *testing.T) {
 	}
 }
 
+var selfCloseTests = []struct {
+	selfClose bool
+	input     string
+	output    string
+}{
+	{false, `<a><img src="x"/><b></b></a>`, `<a><img src='x'></img><b></b></a>`},
+	{true, `<a><img src="x"/><b></b></a>`, `<a><img src='x'/><b/></a>`},
+	{true, `<a><b> </b><!--c--></a>`, `<a><b> </b><!--c--></a>`},
+	{true, `<a xmlns="urn:x"><b xmlns="urn:y"/><c/></a>`, `<a xmlns='urn:x'><b xmlns='urn:y'/><c/></a>`},
+}
+
+func TestIdentitySelfClose(t *testing.T) {
+	for i, v := range selfCloseTests {
+		w := new(bytes.Buffer)
+		h := NewIdentityTransform(w)
+		h.SelfClose = v.selfClose
+		if err := Transform(strings.NewReader(v.input), h); err != nil {
+			t.Fatal(i, err)
+		}
+		if w.String() != v.output {
+			t.Errorf("%d: expected %s, got %s", i, v.output, w.String())
+		}
+	}
+}
+
+func TestIdentityQuote(t *testing.T) {
+	input := `<a b="x'y" c='x"y'>&gt;<b>x]]&gt;y</b></a>`
+	expected := `<a b="x'y" c="x&quot;y">><b>x]]&gt;y</b></a>`
+
+	w := new(bytes.Buffer)
+	h := NewIdentityTransform(w)
+	h.Escape = EscapePolicy{Quote: '"', MinimalQuotes: true, NamedEntities: true, KeepGT: true}
+	if err := Transform(strings.NewReader(input), h); err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+}
+
+var indentTests = []struct {
+	input  string
+	output string
+}{
+	{
+		`<?xml version="1.0"?><a>  <b><c>x</c>` + "\n" + `<d/></b><!--e--></a>`,
+		"<?xml version=\"1.0\"?>\n<a>\n  <b>\n    <c>x</c>\n    <d/>\n  </b>\n  <!--e-->\n</a>",
+	},
+	{
+		`<a><p>Some <em>mixed</em> <b>content</b></p>  <p></p></a>`,
+		"<a>\n  <p>Some <em>mixed</em> <b>content</b></p>\n  <p/>\n</a>",
+	},
+	{
+		`<r><p>text <span><i>x</i> <b>y</b></span></p></r>`,
+		"<r>\n  <p>text <span><i>x</i> <b>y</b></span></p>\n</r>",
+	},
+	{
+		`<a><pre xml:space="preserve">  <b> x </b>  </pre> <c xml:space="preserve"><d xml:space="default"> <e/> </d></c></a>`,
+		"<a>\n  <pre xml:space='preserve'>  <b> x </b>  </pre>\n  <c xml:space='preserve'><d xml:space='default'>\n      <e/>\n    </d></c>\n</a>",
+	},
+}
+
+func TestIdentityIndent(t *testing.T) {
+	for i, v := range indentTests {
+		w := new(bytes.Buffer)
+		h := NewIdentityTransform(w)
+		h.SelfClose = true
+		h.Indent = "  "
+		if err := Transform(strings.NewReader(v.input), h); err != nil {
+			t.Fatal(i, err)
+		}
+		if w.String() != v.output {
+			t.Errorf("%d: expected\n%s\ngot\n%s", i, v.output, w.String())
+		}
+	}
+}
+
+// limitWriter fails once more than n bytes have been written
+type limitWriter struct {
+	n int
+}
+
+var errLimit = errors.New("write limit reached")
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errLimit
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestIdentityWriteError(t *testing.T) {
+	input := `<a xmlns:x="urn:x"><x:b><c>` + strings.Repeat("some text", 1000) + `</c></x:b></a>`
+	err := Transform(strings.NewReader(input), NewIdentityTransform(&limitWriter{n: 30}))
+	if !errors.Is(err, errLimit) {
+		t.Fatalf("expected %v, got %v", errLimit, err)
+	}
+	var werr *WriteError
+	if !errors.As(err, &werr) {
+		t.Fatalf("expected a *WriteError, got %T", err)
+	}
+	if werr.FlushPath != "/a/x:b/c" {
+		t.Errorf("expected path /a/x:b/c, got %s", werr.FlushPath)
+	}
+
+	// an error surfacing only when the pending start tag is flushed
+	h := NewIdentityTransform(&limitWriter{n: 2})
+	if err := h.StartElement(xml.StartElement{Name: xml.Name{Local: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Flush(); !errors.Is(err, errLimit) {
+		t.Errorf("expected %v from Flush, got %v", errLimit, err)
+	}
+
+	if err := h.Comment(xml.Comment("x")); !errors.Is(err, errLimit) {
+		t.Errorf("expected sticky %v, got %v", errLimit, err)
+	}
+}
+
 func compareXml(r1, r2 io.Reader) error {
 	dec1 := xml.NewDecoder(r1)
 	dec2 := xml.NewDecoder(r2)
@@ -50,10 +173,11 @@ func compareXml(r1, r2 io.Reader) error {
 }
 
 func BenchmarkIdentity(b *testing.B) {
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
 		w := new(bytes.Buffer)
-		r := strings.NewReader(xmlTests[0].xml)
+		r := strings.NewReader(xmlTests[len(xmlTests)-1].xml)
 		b.StartTimer()
 		err := Transform(r, NewIdentityTransform(w))
 		if err != nil {
@@ -76,7 +200,7 @@ var xmlTests = []xmlTest{
 	},
 	xmlTest{
 		"Truncated ",
-		fmt.Errorf("XML syntax error on line 1: unexpected EOF"),
+		fmt.Errorf("/, line 1, column 1: XML syntax error: unexpected EOF"),
 		`<x/`,
 	},
 	xmlTest{
@@ -121,3 +245,27 @@ var xmlTests = []xmlTest{
   </atom:content>
   <!-- <test pattern="SECAM" /><test pattern="NTSC" /> -->
 </atom:entry>`}}
+
+func TestIdentityKeepGTSplit(t *testing.T) {
+	w := new(bytes.Buffer)
+	h := NewIdentityTransform(w)
+	h.Escape = EscapePolicy{KeepGT: true}
+	for _, tok := range []xml.Token{
+		xml.StartElement{Name: xml.Name{Local: "a"}},
+		xml.CharData("x]"), xml.CharData("]"), xml.CharData(">y>"),
+		xml.StartElement{Name: xml.Name{Local: "b"}},
+		xml.CharData("]]"), xml.EndElement{Name: xml.Name{Local: "b"}},
+		xml.CharData(">"),
+		xml.EndElement{Name: xml.Name{Local: "a"}},
+	} {
+		if err := h.Emit(tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `<a>x]]&gt;y><b>]]</b>></a>`; w.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+}
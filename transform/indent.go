@@ -0,0 +1,142 @@
+package transform
+
+import (
+	"encoding/xml"
+)
+
+// level records the state of an open element in an IdentityTransform
+type level struct {
+	name     xml.Name
+	preserve bool // xml:space="preserve" is in effect
+	mixed    bool // non-whitespace character data has been seen here or in an ancestor
+	children bool // element, comment, or processing instruction content has been written
+}
+
+const xmlSpaceLocal = "space"
+
+var newline = []byte("\n")
+
+// push records the start of an element, inheriting xml:space and
+// mixed content from the enclosing element
+func (t *IdentityTransform) push(node xml.StartElement) {
+	l := level{name: node.Name}
+	if n := len(t.levels); n > 0 {
+		l.preserve = t.levels[n-1].preserve
+		l.mixed = t.levels[n-1].mixed
+	}
+	for _, attr := range node.Attr {
+		if (attr.Name.Space == xmlSpace || attr.Name.Space == xmlPrefix) && attr.Name.Local == xmlSpaceLocal {
+			l.preserve = attr.Value == "preserve"
+		}
+	}
+	t.levels = append(t.levels, l)
+}
+
+// pop records the end of an element
+func (t *IdentityTransform) pop() {
+	if n := len(t.levels); n > 0 {
+		t.levels = t.levels[:n-1]
+	}
+}
+
+// verbatim reports whether whitespace must be written as is in the
+// current element: either xml:space="preserve" is in effect, or the
+// element or one of its ancestors has been found to contain mixed
+// content.
+//
+// Because the transform is streamed, an element is only known to
+// have mixed content once non-whitespace character data has been
+// seen.  Whitespace between child elements that precede the first
+// such character data will already have been reindented.  Elements
+// whose first content is text are left untouched.
+func (t *IdentityTransform) verbatim() bool {
+	n := len(t.levels)
+	return n > 0 && (t.levels[n-1].preserve || t.levels[n-1].mixed)
+}
+
+// holdSpace examines character data when indenting.  Whitespace-only
+// data outside of verbatim content is held back, to be discarded or
+// replaced by indentation, and true is returned.  Otherwise any
+// whitespace held back is written, and false is returned so that the
+// caller writes node.
+func (t *IdentityTransform) holdSpace(node xml.CharData) bool {
+	if !t.verbatim() {
+		if isSpace(node) {
+			t.ws = append(t.ws, node...)
+			return true
+		}
+		if n := len(t.levels); n > 0 {
+			t.levels[n-1].mixed = true
+		}
+	}
+	t.writeSpace()
+	return false
+}
+
+// writeSpace writes out any whitespace held back
+func (t *IdentityTransform) writeSpace() {
+	if len(t.ws) > 0 {
+		t.closeStart()
+		t.escape(t.ws, CharData)
+		t.ws = t.ws[:0]
+	}
+}
+
+// indent is called before writing an element, comment, processing
+// instruction or directive.  When indenting it replaces any
+// whitespace held back with a newline and indentation.
+func (t *IdentityTransform) indent() {
+	if t.Indent == "" {
+		return
+	}
+	if t.verbatim() {
+		t.writeSpace()
+		return
+	}
+
+	t.ws = t.ws[:0]
+	n := len(t.levels)
+	if n > 0 {
+		t.levels[n-1].children = true
+	}
+	if t.started {
+		t.writeIndent(n)
+	}
+	t.started = true
+}
+
+// indentEnd is called before writing an end tag.  When indenting, if
+// the element has children the end tag is placed on a new line.
+func (t *IdentityTransform) indentEnd() {
+	if t.Indent == "" {
+		return
+	}
+	if t.verbatim() {
+		t.writeSpace()
+		return
+	}
+
+	t.ws = t.ws[:0]
+	if n := len(t.levels); n > 0 && t.levels[n-1].children {
+		t.closeStart()
+		t.writeIndent(n - 1)
+	}
+}
+
+func (t *IdentityTransform) writeIndent(depth int) {
+	t.write(newline)
+	for i := 0; i < depth; i++ {
+		t.writeString(t.Indent)
+	}
+}
+
+func isSpace(b []byte) bool {
+	for _, c := range b {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// inputReader is the input of the decoder when resynchronizing or
+// keeping the original bytes of tokens.  It is read by the decoder
+// one byte at a time, so that no input is read beyond the byte the
+// decoder may hold back.  Following a resync it returns a prefix of
+// synthetic start tags before the remaining input.
+type inputReader struct {
+	br     *bufio.Reader
+	prefix []byte
+	n      int64 // bytes of input read
+
+	keep bool   // record the input read
+	buf  []byte // input recorded, starting at offset off
+	off  int64
+
+	exp *expansion // entity expansion charged to the input, if limited
+}
+
+func newInputReader(r io.Reader) *inputReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &inputReader{br: br}
+}
+
+func (in *inputReader) ReadByte() (b byte, err error) {
+	if len(in.prefix) > 0 {
+		b = in.prefix[0]
+		in.prefix = in.prefix[1:]
+		return
+	}
+	if b, err = in.br.ReadByte(); err == nil {
+		in.n++
+		if in.keep {
+			in.buf = append(in.buf, b)
+		}
+		if in.exp != nil {
+			err = in.exp.scan(b)
+		}
+	}
+	return
+}
+
+func (in *inputReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return
+	}
+	if p[0], err = in.ReadByte(); err == nil {
+		n = 1
+	}
+	return
+}
+
+// unread returns the last byte of input read to the reader
+func (in *inputReader) unread() {
+	if in.br.UnreadByte() == nil {
+		in.n--
+		if n := len(in.buf); n > 0 {
+			in.buf = in.buf[:n-1]
+		}
+	}
+}
+
+// discard drops the input recorded before offset
+func (in *inputReader) discard(offset int64) {
+	k := offset - in.off
+	if k <= 0 {
+		return
+	}
+	if k > int64(len(in.buf)) {
+		k = int64(len(in.buf))
+	}
+	n := copy(in.buf, in.buf[k:])
+	in.buf = in.buf[:n]
+	in.off = offset
+}
+
+// span returns the input recorded from start up to end
+func (in *inputReader) span(start, end int64) []byte {
+	i, j := start-in.off, end-in.off
+	if i < 0 || j < i || j > int64(len(in.buf)) {
+		return nil
+	}
+	return in.buf[i:j]
+}
+
+// reset discards the input recorded, which resumes at offset
+func (in *inputReader) reset(offset int64) {
+	in.buf = in.buf[:0]
+	in.off = offset
+}
+
+// decodeCharset converts the input to UTF-8 ahead of the decoder,
+// according to the encoding named in its XML declaration.  If the
+// encoding cannot be converted the input is returned as is, and the
+// decoder will report the error.
+func decodeCharset(r io.Reader, charsetReader func(string, io.Reader) (io.Reader, error)) (_ io.Reader, converted bool) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	b, _ := br.Peek(256)
+	if !bytes.HasPrefix(b, []byte("<?xml")) {
+		return br, false
+	}
+	end := bytes.Index(b, []byte("?>"))
+	if end < 0 {
+		return br, false
+	}
+	enc := strings.ToLower(procInstParam(b[len("<?xml"):end], "encoding"))
+	if enc == "" || enc == "utf-8" {
+		return br, false
+	}
+	cr, err := charsetReader(enc, br)
+	if err != nil {
+		return br, false
+	}
+	return cr, true
+}
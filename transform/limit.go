@@ -0,0 +1,116 @@
+package transform
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// Limit identifies one of the resource limits of TransformOptions
+type Limit int
+
+const (
+	LimitDepth Limit = iota
+	LimitAttrs
+	LimitTokenSize
+	LimitBytes
+	LimitTokens
+	LimitEntityExpansion
+)
+
+var limitNames = []string{
+	LimitDepth:           "element depth",
+	LimitAttrs:           "attribute count",
+	LimitTokenSize:       "token size",
+	LimitBytes:           "input size",
+	LimitTokens:          "token count",
+	LimitEntityExpansion: "entity expansion",
+}
+
+func (l Limit) String() string {
+	if l >= 0 && int(l) < len(limitNames) {
+		return limitNames[l]
+	}
+	return fmt.Sprintf("Limit(%d)", int(l))
+}
+
+// LimitError reports that a document exceeded one of the limits set
+// in TransformOptions
+type LimitError struct {
+	Limit Limit
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit of %d exceeded", e.Limit, e.Max)
+}
+
+// defaultMaxEntityExpansion limits the expansion of entities when
+// neither MaxEntityExpansion nor MaxBytes is set
+const defaultMaxEntityExpansion = 1 << 26
+
+// maxEntityExpansion returns the limit on the expansion of entities
+func (opts *TransformOptions) maxEntityExpansion() int64 {
+	switch {
+	case opts.MaxEntityExpansion > 0:
+		return opts.MaxEntityExpansion
+	case opts.MaxBytes > 0:
+		return opts.MaxBytes
+	}
+	return defaultMaxEntityExpansion
+}
+
+// limit checks tok against the limits set in the options
+func (t *transformer) limit(tok xml.Token) error {
+	opts := t.opts
+
+	t.tokens++
+	if opts.MaxTokens > 0 && t.tokens > opts.MaxTokens {
+		return &LimitError{Limit: LimitTokens, Max: opts.MaxTokens}
+	}
+
+	_, _, offset := t.inputPos()
+	size := offset - t.offset
+	t.offset = offset
+	if opts.MaxTokenSize > 0 && size > opts.MaxTokenSize {
+		return &LimitError{Limit: LimitTokenSize, Max: opts.MaxTokenSize}
+	}
+
+	switch node := tok.(type) {
+	case xml.StartElement:
+		t.depth++
+		if opts.MaxDepth > 0 && t.depth > opts.MaxDepth {
+			return &LimitError{Limit: LimitDepth, Max: int64(opts.MaxDepth)}
+		}
+		if opts.MaxAttrs > 0 && len(node.Attr) > opts.MaxAttrs {
+			return &LimitError{Limit: LimitAttrs, Max: int64(opts.MaxAttrs)}
+		}
+	case xml.EndElement:
+		t.depth--
+	}
+	return nil
+}
+
+// limitReader returns a *LimitError once more than n bytes have been
+// read from r
+type limitReader struct {
+	r    io.Reader
+	n    int64
+	read int64
+}
+
+func (lr *limitReader) Read(p []byte) (n int, err error) {
+	if lr.read > lr.n {
+		return 0, &LimitError{Limit: LimitBytes, Max: lr.n}
+	}
+	if int64(len(p)) > lr.n-lr.read+1 {
+		p = p[:lr.n-lr.read+1]
+	}
+	n, err = lr.r.Read(p)
+	lr.read += int64(n)
+	if lr.read > lr.n {
+		n = int(int64(n) - (lr.read - lr.n))
+		err = &LimitError{Limit: LimitBytes, Max: lr.n}
+	}
+	return
+}
@@ -0,0 +1,139 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+)
+
+// Position identifies a location in the input of a transform
+type Position struct {
+	Line   int   // line number, starting at 1
+	Column int   // column number, in bytes, starting at 1
+	Offset int64 // byte offset, starting at 0
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// Locator reports where in the document the event being handled
+// was found.
+type Locator interface {
+	// Position returns the position of the start of the current
+	// token
+	Position() Position
+
+	// Path returns the path of the current element, rendered as
+	// directed by TransformOptions.PathFormat.  During
+	// StartElement and EndElement the current element is the one
+	// being started or ended.
+	Path() string
+
+	// Token returns a copy of the current token as it was read,
+	// and Raw returns its bytes of input, when the transform was
+	// run with TransformOptions.KeepOriginal.  Otherwise both
+	// return nil.  Raw is empty for tokens that do not appear in
+	// the input, such as the EndElement of an empty-element tag.
+	// The bytes returned by Raw are only valid until the handler
+	// returns.
+	Token() xml.Token
+	Raw() []byte
+}
+
+// LocatorSetter may be implemented by a Handler that wants to know
+// where in the document the events it handles were found.  Transform
+// calls SetLocator before reporting the first event.  The Locator is
+// only valid for the duration of the transform.
+type LocatorSetter interface {
+	SetLocator(Locator)
+}
+
+// TransformError reports an error encountered by Transform, along
+// with the position of the start of the token being parsed or
+// handled and the path of the current element.
+type TransformError struct {
+	Position
+	Path string
+	Err  error
+}
+
+// Error formats the error with its path and position.  The line
+// number reported by an *xml.SyntaxError is left out in favour of
+// the position.
+func (e *TransformError) Error() string {
+	if serr, ok := e.Err.(*xml.SyntaxError); ok {
+		return fmt.Sprintf("%s, %s: XML syntax error: %s", e.Path, e.Position, serr.Msg)
+	}
+	return fmt.Sprintf("%s, %s: %v", e.Path, e.Position, e.Err)
+}
+
+func (e *TransformError) Unwrap() error {
+	return e.Err
+}
+
+// mark records the current position of the decoder as the start of
+// the current token
+func (t *transformer) mark() {
+	line, column, offset := t.inputPos()
+	t.pos = Position{Line: line, Column: column, Offset: offset}
+}
+
+// inputPos returns the position of the decoder in the input, allowing
+// for a decoder restarted by resync
+func (t *transformer) inputPos() (line, column int, offset int64) {
+	line, column = t.dec.InputPos()
+	offset = t.dec.InputOffset()
+	if t.input == nil {
+		return
+	}
+	offset = t.base.Offset + offset - t.prefix
+	if line == 1 {
+		column = t.base.Column + column - int(t.prefix) - 1
+	}
+	line = t.base.Line + line - 1
+	return
+}
+
+// wrap returns err as a *TransformError
+func (t *transformer) wrap(err error) error {
+	if _, ok := err.(*TransformError); ok {
+		return err
+	}
+	return &TransformError{Position: t.pos, Path: t.Path(), Err: err}
+}
+
+func (t *transformer) Position() Position {
+	return t.pos
+}
+
+func (t *transformer) Path() string {
+	return t.path.Format(t.opts.PathFormat)
+}
+
+func (t *transformer) Token() xml.Token {
+	return t.tok
+}
+
+func (t *transformer) Raw() []byte {
+	if !t.opts.KeepOriginal {
+		return nil
+	}
+	return t.raw
+}
+
+// keep records the bytes of input of tok, which start at the
+// position marked, and a copy of tok when KeepOriginal is set.  A
+// CDATA section is returned as CData when CDATA is set.
+func (t *transformer) keep(tok xml.Token) xml.Token {
+	_, _, end := t.inputPos()
+	t.input.discard(t.pos.Offset)
+	t.raw = t.input.span(t.pos.Offset, end)
+	if node, ok := tok.(xml.CharData); ok && t.opts.CDATA && bytes.HasPrefix(t.raw, startCData) {
+		tok = CData(node)
+	}
+	if t.opts.KeepOriginal {
+		t.tok = copyToken(tok)
+	}
+	return tok
+}
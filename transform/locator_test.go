@@ -0,0 +1,118 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jimrobinson/xml/xmlpath"
+)
+
+// locatorHandler records the position and path of each start element,
+// and fails on elements named "fail"
+type locatorHandler struct {
+	*IdentityTransform
+	loc  Locator
+	seen []string
+}
+
+var errFail = errors.New("fail")
+
+func (h *locatorHandler) SetLocator(loc Locator) {
+	h.loc = loc
+}
+
+func (h *locatorHandler) StartElement(node xml.StartElement) error {
+	pos := h.loc.Position()
+	h.seen = append(h.seen, fmt.Sprintf("%s %d:%d@%d", h.loc.Path(), pos.Line, pos.Column, pos.Offset))
+	if node.Name.Local == "fail" {
+		return errFail
+	}
+	return h.IdentityTransform.StartElement(node)
+}
+
+const locatorInput = `<a xmlns:x="urn:x">
+  <x:b>
+    <c/><fail/>
+  </x:b>
+</a>`
+
+func TestLocator(t *testing.T) {
+	h := &locatorHandler{IdentityTransform: NewIdentityTransform(new(bytes.Buffer))}
+	err := Transform(strings.NewReader(locatorInput), NewPipeline(h, &Filter{}))
+
+	expected := []string{
+		"/a 1:1@0",
+		"/a/x:b 2:3@22",
+		"/a/x:b/c 3:5@32",
+		"/a/x:b/fail 3:9@36",
+	}
+	if strings.Join(h.seen, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(h.seen, "\n"))
+	}
+
+	var terr *TransformError
+	if !errors.As(err, &terr) {
+		t.Fatalf("expected a *TransformError, got %v", err)
+	}
+	if !errors.Is(err, errFail) {
+		t.Errorf("expected %v to wrap %v", err, errFail)
+	}
+	if terr.Line != 3 || terr.Column != 9 || terr.Offset != 36 || terr.Path != "/a/x:b/fail" {
+		t.Errorf("unexpected error position: %v", terr)
+	}
+}
+
+func TestSyntaxErrorPosition(t *testing.T) {
+	err := Transform(strings.NewReader("<a>\n  <b></c>\n</a>"), NewIdentityTransform(new(bytes.Buffer)))
+	var terr *TransformError
+	if !errors.As(err, &terr) {
+		t.Fatalf("expected a *TransformError, got %v", err)
+	}
+	if terr.Line != 2 || terr.Column != 6 || terr.Path != "/a/b" {
+		t.Errorf("unexpected error position: %v", terr)
+	}
+	var serr *xml.SyntaxError
+	if !errors.As(err, &serr) {
+		t.Errorf("expected %v to wrap a *xml.SyntaxError", err)
+	}
+	if expected := "/a/b, line 2, column 6: XML syntax error: element <b> closed by </c>"; err.Error() != expected {
+		t.Errorf("expected %s, got %s", expected, err.Error())
+	}
+}
+
+func TestLimitErrorNotRecovered(t *testing.T) {
+	h := &recoverHandler{IdentityTransform: NewIdentityTransform(new(bytes.Buffer))}
+	opts := NewTransformOptions()
+	opts.MaxBytes = 16
+	err := TransformWithOptions(strings.NewReader(limitInput), h, opts)
+	var lerr *LimitError
+	if !errors.As(err, &lerr) || lerr.Limit != LimitBytes {
+		t.Errorf("expected a *LimitError, got %v", err)
+	}
+	if h.errs != 0 {
+		t.Errorf("expected the limit error not to be passed to the handler, got %d errors", h.errs)
+	}
+}
+
+func TestPathFormat(t *testing.T) {
+	h := &positionHandler{IdentityTransform: NewIdentityTransform(new(bytes.Buffer))}
+	opts := NewTransformOptions()
+	opts.PathFormat = xmlpath.Format{Positions: true, Attrs: []xml.Name{{Local: "id"}}}
+	if err := TransformWithOptions(strings.NewReader(`<a><b/><b id="x"><c/></b></a>`), h, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"/a[1] 1:1@0",
+		"/a[1]/b[1] 1:4@3",
+		"/a[1]/b[@id='x'] 1:8@7",
+		"/a[1]/b[@id='x']/c[1] 1:18@17",
+	}
+	if strings.Join(h.seen, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(h.seen, "\n"))
+	}
+}
@@ -0,0 +1,245 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+)
+
+// LosslessTransform is an IdentityTransform that copies the input of
+// each token that reaches it unchanged, so that the parts of a
+// document that are not modified are written exactly as they were
+// read: attribute quoting, entity and character references, CDATA
+// sections and whitespace within tags are all kept.  Tokens that have
+// been modified, or that do not appear in the input, are serialized
+// by the IdentityTransform.
+//
+// The input of each token is only available when the transform is
+// run with TransformOptions.KeepOriginal; otherwise every token is
+// serialized.  The input copied is that seen by the decoder, after
+// conversion to UTF-8 and removal of any byte order mark, so the
+// output is byte for byte identical to an unmodified input only for
+// documents in UTF-8.
+//
+// The Indent setting of the IdentityTransform only applies to tokens
+// that are serialized, as does the Declaration setting, except that
+// an XML declaration is only copied when DeclPreserve is in effect
+// for the output Charset.  With a Charset, characters it cannot
+// represent are written as character references in copied character
+// data and CDATA sections, as they are when serialized.
+type LosslessTransform struct {
+	*IdentityTransform
+	loc   Locator
+	empty bool // the pending start tag was copied from an empty-element tag
+}
+
+func NewLosslessTransform(w io.Writer) *LosslessTransform {
+	return &LosslessTransform{IdentityTransform: NewIdentityTransform(w)}
+}
+
+func (t *LosslessTransform) SetLocator(loc Locator) {
+	t.loc = loc
+}
+
+// Emit serializes tok by passing it to the appropriate handler method
+func (t *LosslessTransform) Emit(tok xml.Token) error {
+	return Dispatch(t, tok)
+}
+
+// original returns the input of tok, if tok is the token being
+// handled and has not been modified, or nil
+func (t *LosslessTransform) original(tok xml.Token) []byte {
+	if t.loc == nil {
+		return nil
+	}
+	raw := t.loc.Raw()
+	if len(raw) == 0 || !equalToken(tok, t.loc.Token()) {
+		return nil
+	}
+	return raw
+}
+
+// copyRaw writes raw in place of a token other than an element
+func (t *LosslessTransform) copyRaw(raw []byte) error {
+	t.begin()
+	t.closeStart()
+	t.write(raw)
+	return t.err
+}
+
+// copyText writes raw in place of character data, which may include
+// CDATA sections
+func (t *LosslessTransform) copyText(raw []byte) error {
+	t.begin()
+	t.closeStart()
+	for len(raw) > 0 && t.err == nil {
+		text := raw
+		if i := bytes.Index(raw, startCData); i >= 0 {
+			text = raw[:i]
+		}
+		_, err := t.out(true).Write(text)
+		t.fail(err)
+		t.brackets = len(text) - len(bytes.TrimRight(text, "]"))
+		raw = raw[len(text):]
+
+		if len(raw) > 0 {
+			n := len(raw)
+			if i := bytes.Index(raw, endCData); i >= 0 {
+				n = i + len(endCData)
+			}
+			t.writeCData(raw[:n])
+			raw = raw[n:]
+		}
+	}
+	return t.err
+}
+
+// copyCData writes raw in place of a CDATA section
+func (t *LosslessTransform) copyCData(raw []byte) error {
+	t.begin()
+	t.closeStart()
+	t.writeCData(raw)
+	return t.err
+}
+
+func (t *LosslessTransform) StartElement(node xml.StartElement) error {
+	t.empty = false
+	raw := t.original(node)
+	if raw == nil {
+		return t.IdentityTransform.StartElement(node)
+	}
+	if t.err != nil {
+		return t.err
+	}
+	t.begin()
+	t.closeStart()
+	t.push(node)
+	t.ns.Push(node)
+
+	// the end of an empty-element tag is held back, in case
+	// content is added to the element
+	if bytes.HasSuffix(raw, endEmptyElement) {
+		t.write(raw[:len(raw)-len(endEmptyElement)])
+		t.pending = true
+		t.empty = true
+	} else {
+		t.write(raw)
+	}
+	return t.err
+}
+
+func (t *LosslessTransform) EndElement(node xml.EndElement) error {
+	empty := t.empty
+	t.empty = false
+	if t.err != nil {
+		return t.err
+	}
+	if empty && t.pending {
+		t.write(endEmptyElement)
+		t.pending = false
+		t.pop()
+		t.ns.Pop()
+		return t.err
+	}
+
+	raw := t.original(node)
+	if raw == nil {
+		return t.IdentityTransform.EndElement(node)
+	}
+	t.closeStart()
+	t.write(raw)
+	t.pop()
+	t.ns.Pop()
+	return t.err
+}
+
+func (t *LosslessTransform) CharData(node xml.CharData) error {
+	raw := t.original(node)
+	if raw == nil {
+		return t.IdentityTransform.CharData(node)
+	}
+	if t.err != nil {
+		return t.err
+	}
+	return t.copyText(raw)
+}
+
+func (t *LosslessTransform) CData(node CData) error {
+	raw := t.original(node)
+	if raw == nil {
+		return t.IdentityTransform.CData(node)
+	}
+	if t.err != nil {
+		return t.err
+	}
+	return t.copyCData(raw)
+}
+
+func (t *LosslessTransform) Comment(node xml.Comment) error {
+	raw := t.original(node)
+	if raw == nil {
+		return t.IdentityTransform.Comment(node)
+	}
+	if t.err != nil {
+		return t.err
+	}
+	return t.copyRaw(raw)
+}
+
+func (t *LosslessTransform) Directive(node xml.Directive) error {
+	raw := t.original(node)
+	if raw == nil {
+		return t.IdentityTransform.Directive(node)
+	}
+	if t.err != nil {
+		return t.err
+	}
+	return t.copyRaw(raw)
+}
+
+func (t *LosslessTransform) ProcInst(node xml.ProcInst) error {
+	raw := t.original(node)
+	if raw == nil || (node.Target == xmlPrefix && t.declMode() != DeclPreserve) {
+		return t.IdentityTransform.ProcInst(node)
+	}
+	if t.err != nil {
+		return t.err
+	}
+	return t.copyRaw(raw)
+}
+
+// equalToken reports whether a and b are the same token
+func equalToken(a, b xml.Token) bool {
+	switch a := a.(type) {
+	case xml.StartElement:
+		b, ok := b.(xml.StartElement)
+		if !ok || a.Name != b.Name || len(a.Attr) != len(b.Attr) {
+			return false
+		}
+		for i := range a.Attr {
+			if a.Attr[i] != b.Attr[i] {
+				return false
+			}
+		}
+		return true
+	case xml.EndElement:
+		b, ok := b.(xml.EndElement)
+		return ok && a.Name == b.Name
+	case xml.CharData:
+		b, ok := b.(xml.CharData)
+		return ok && bytes.Equal(a, b)
+	case CData:
+		b, ok := b.(CData)
+		return ok && bytes.Equal(a, b)
+	case xml.Comment:
+		b, ok := b.(xml.Comment)
+		return ok && bytes.Equal(a, b)
+	case xml.Directive:
+		b, ok := b.(xml.Directive)
+		return ok && bytes.Equal(a, b)
+	case xml.ProcInst:
+		b, ok := b.(xml.ProcInst)
+		return ok && a.Target == b.Target && bytes.Equal(a.Inst, b.Inst)
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+const losslessInput = "<?xml version='1.0'?>\n" +
+	"<!DOCTYPE a>\n" +
+	"<a  x = \"1\" y='&lt;'>text &amp; <![CDATA[<cdata>]]><b/><c  />&#65;<!-- c --><?pi  x?>\n" +
+	"  <d\n    e=\"&#x20;\">keep</d><f/>\n" +
+	"</a >\n"
+
+// renameB renames <b> elements to <B>, and replaces the content of
+// <d> elements
+var renameB = TokenHandlerFunc(func(tok xml.Token, out Emitter) error {
+	switch node := tok.(type) {
+	case xml.StartElement:
+		if node.Name.Local == "b" {
+			node.Name.Local = "B"
+			return out.Emit(node)
+		}
+	case xml.EndElement:
+		if node.Name.Local == "b" {
+			node.Name.Local = "B"
+			return out.Emit(node)
+		}
+	case xml.CharData:
+		if string(node) == "keep" {
+			return out.Emit(xml.CharData("changed & escaped"))
+		}
+	}
+	return out.Emit(tok)
+})
+
+// fillF adds content to <f> elements
+var fillF = TokenHandlerFunc(func(tok xml.Token, out Emitter) error {
+	if err := out.Emit(tok); err != nil {
+		return err
+	}
+	if node, ok := tok.(xml.StartElement); ok && node.Name.Local == "f" {
+		return out.Emit(xml.CharData("filled"))
+	}
+	return nil
+})
+
+type losslessTest struct {
+	descr  string
+	opts   *TransformOptions
+	stages []Stage
+	output string
+}
+
+var losslessTests = []losslessTest{
+	{
+		"Unmodified",
+		&TransformOptions{Strict: true, KeepOriginal: true},
+		nil,
+		losslessInput,
+	},
+	{
+		"Unmodified raw tokens",
+		&TransformOptions{Strict: true, KeepOriginal: true, RawTokens: true},
+		nil,
+		losslessInput,
+	},
+	{
+		"Modified",
+		&TransformOptions{Strict: true, KeepOriginal: true},
+		[]Stage{NewTokenStage(renameB), NewTokenStage(fillF)},
+		strings.NewReplacer(
+			"<b/>", "<B></B>",
+			"keep", "changed &amp; escaped",
+			"<f/>", "<f>filled</f>",
+		).Replace(losslessInput),
+	},
+	{
+		"Without KeepOriginal",
+		NewTransformOptions(),
+		nil,
+		"<?xml version='1.0'?>\n" +
+			"<!DOCTYPE a>\n" +
+			"<a x='1' y='&lt;'>text &amp; &lt;cdata&gt;<b></b><c></c>A<!-- c --><?pi x?>\n" +
+			"  <d e=' '>keep</d><f></f>\n" +
+			"</a>\n",
+	},
+}
+
+func TestLossless(t *testing.T) {
+	for _, v := range losslessTests {
+		w := new(bytes.Buffer)
+		p := NewPipeline(NewLosslessTransform(w), v.stages...)
+		if err := TransformWithOptions(strings.NewReader(losslessInput), p, v.opts); err != nil {
+			t.Errorf("%s: %v", v.descr, err)
+			continue
+		}
+		if w.String() != v.output {
+			t.Errorf("%s: expected\n%s\ngot\n%s", v.descr, v.output, w.String())
+		}
+	}
+}
+
+func TestLosslessResync(t *testing.T) {
+	opts := NewTransformOptions()
+	opts.KeepOriginal = true
+	opts.Recovery = RecoverResync
+
+	w := new(bytes.Buffer)
+	h := &LosslessTransform{IdentityTransform: NewIdentityTransform(w)}
+	rh := &recoverLossless{h}
+	input := `<a x = "1"><b>x</c> <d  y="2"/></b></a>`
+	if err := TransformWithOptions(strings.NewReader(input), rh, opts); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `<a x = "1"><b>x<d  y="2"/></b></a>`; w.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+}
+
+// recoverLossless asks for every error to be recovered from
+type recoverLossless struct {
+	*LosslessTransform
+}
+
+func (h *recoverLossless) Error(err error) (abort bool) {
+	return false
+}
+
+func TestLosslessCharset(t *testing.T) {
+	input := "<?xml version='1.0'?>\n<a x='1'>é 名 <![CDATA[x名]]><!--c--></a>"
+	expected := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n" +
+		"<a x='1'>\xe9 &#21517; <![CDATA[x]]>&#21517;<![CDATA[]]><!--c--></a>"
+	for _, cdata := range []bool{false, true} {
+		opts := NewTransformOptions()
+		opts.KeepOriginal = true
+		opts.CDATA = cdata
+
+		w := new(bytes.Buffer)
+		h := NewLosslessTransform(w)
+		h.Charset = ISO88591
+		if err := TransformWithOptions(strings.NewReader(input), h, opts); err != nil {
+			t.Fatal(err)
+		}
+		if w.String() != expected {
+			t.Errorf("CDATA %v: expected\n%q\ngot\n%q", cdata, expected, w.String())
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package transform
+
+import (
+	"encoding/xml"
+)
+
+// Stage is a Handler that passes the events it handles on to a
+// downstream Handler.  Stages are chained together by a Pipeline.
+type Stage interface {
+	Handler
+
+	// SetNext sets the Handler that will receive the events
+	// emitted by this stage
+	SetNext(Handler)
+}
+
+// Filter implements a Stage that passes every event through to the
+// Next handler unchanged.
+//
+// Embed a *Filter and override the methods of interest in order to
+// drop, rewrite, or inject events.  An overriding method passes an
+// event downstream by calling the corresponding Filter method, or by
+// calling Emit.  Overriding methods that do not call through to the
+// Filter drop the event.
+//
+// Filter is not a CDataHandler, so CDATA sections reach a stage
+// embedding it through its CharData method, and are passed on as
+// character data.  A stage that implements CDataHandler itself
+// receives them as CData instead, and may pass them on with Emit.
+type Filter struct {
+	Next Handler
+}
+
+func (f *Filter) SetNext(next Handler) {
+	f.Next = next
+}
+
+// Emit passes tok to the Next handler.  It may be called any number
+// of times from within a Filter method to inject additional events.
+func (f *Filter) Emit(tok xml.Token) error {
+	return Dispatch(f.Next, tok)
+}
+
+// SetLocator passes loc on to the Next handler, if it is a
+// LocatorSetter.  A type embedding Filter that overrides SetLocator
+// should call through to the Filter method.
+func (f *Filter) SetLocator(loc Locator) {
+	if ls, ok := f.Next.(LocatorSetter); ok {
+		ls.SetLocator(loc)
+	}
+}
+
+// SetOptions passes opts on to the Next handler, if it is an
+// OptionsSetter.  A type embedding Filter that overrides SetOptions
+// should call through to the Filter method.
+func (f *Filter) SetOptions(opts *TransformOptions) {
+	if setter, ok := f.Next.(OptionsSetter); ok {
+		setter.SetOptions(opts)
+	}
+}
+
+func (f *Filter) StartElement(node xml.StartElement) error {
+	return f.Next.StartElement(node)
+}
+
+func (f *Filter) EndElement(node xml.EndElement) error {
+	return f.Next.EndElement(node)
+}
+
+func (f *Filter) CharData(node xml.CharData) error {
+	return f.Next.CharData(node)
+}
+
+func (f *Filter) Comment(node xml.Comment) error {
+	return f.Next.Comment(node)
+}
+
+func (f *Filter) Directive(node xml.Directive) error {
+	return f.Next.Directive(node)
+}
+
+func (f *Filter) ProcInst(node xml.ProcInst) error {
+	return f.Next.ProcInst(node)
+}
+
+func (f *Filter) Flush() error {
+	return f.Next.Flush()
+}
+
+func (f *Filter) Error(err error) (abort bool) {
+	return f.Next.Error(err)
+}
+
+// Pipeline implements a Handler that passes each event through a
+// chain of stages, the last of which passes its events on to a final
+// Handler (typically an IdentityTransform).
+//
+// Flush and Error are passed to the first stage, and a Filter passes
+// them along the chain, so stages that override Flush or Error
+// should call through to the Filter method.
+type Pipeline struct {
+	head   Handler
+	stages []Stage
+}
+
+// NewPipeline returns a Pipeline that sends events through stages,
+// in order, and then on to sink.
+func NewPipeline(sink Handler, stages ...Stage) *Pipeline {
+	p := &Pipeline{stages: stages}
+	p.SetNext(sink)
+	return p
+}
+
+// SetNext replaces the final Handler of the pipeline.  This allows a
+// Pipeline to be used as a Stage of another Pipeline.
+func (p *Pipeline) SetNext(next Handler) {
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		p.stages[i].SetNext(next)
+		next = p.stages[i]
+	}
+	p.head = next
+}
+
+func (p *Pipeline) SetLocator(loc Locator) {
+	if ls, ok := p.head.(LocatorSetter); ok {
+		ls.SetLocator(loc)
+	}
+}
+
+func (p *Pipeline) SetOptions(opts *TransformOptions) {
+	if setter, ok := p.head.(OptionsSetter); ok {
+		setter.SetOptions(opts)
+	}
+}
+
+func (p *Pipeline) StartElement(node xml.StartElement) error {
+	return p.head.StartElement(node)
+}
+
+func (p *Pipeline) EndElement(node xml.EndElement) error {
+	return p.head.EndElement(node)
+}
+
+func (p *Pipeline) CharData(node xml.CharData) error {
+	return p.head.CharData(node)
+}
+
+func (p *Pipeline) CData(node CData) error {
+	return Dispatch(p.head, node)
+}
+
+func (p *Pipeline) Comment(node xml.Comment) error {
+	return p.head.Comment(node)
+}
+
+func (p *Pipeline) Directive(node xml.Directive) error {
+	return p.head.Directive(node)
+}
+
+func (p *Pipeline) ProcInst(node xml.ProcInst) error {
+	return p.head.ProcInst(node)
+}
+
+func (p *Pipeline) Flush() error {
+	return p.head.Flush()
+}
+
+func (p *Pipeline) Error(err error) (abort bool) {
+	return p.head.Error(err)
+}
@@ -0,0 +1,105 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// renameFilter renames elements from one local name to another
+type renameFilter struct {
+	Filter
+	from, to string
+}
+
+func (f *renameFilter) StartElement(node xml.StartElement) error {
+	if node.Name.Local == f.from {
+		node.Name.Local = f.to
+	}
+	return f.Filter.StartElement(node)
+}
+
+func (f *renameFilter) EndElement(node xml.EndElement) error {
+	if node.Name.Local == f.from {
+		node.Name.Local = f.to
+	}
+	return f.Filter.EndElement(node)
+}
+
+// commentFilter drops comments and wraps character data in <t>
+type commentFilter struct {
+	Filter
+}
+
+func (f *commentFilter) Comment(node xml.Comment) error {
+	return nil
+}
+
+func (f *commentFilter) CharData(node xml.CharData) (err error) {
+	t := xml.Name{Local: "t"}
+	if err = f.Emit(xml.StartElement{Name: t}); err != nil {
+		return
+	}
+	if err = f.Filter.CharData(node); err != nil {
+		return
+	}
+	return f.Emit(xml.EndElement{Name: t})
+}
+
+type pipelineTest struct {
+	descr  string
+	stages func() []Stage
+	input  string
+	output string
+}
+
+var pipelineTests = []pipelineTest{
+	{
+		"No stages",
+		func() []Stage { return nil },
+		`<a><!--c--><b>x</b></a>`,
+		`<a><!--c--><b>x</b></a>`,
+	},
+	{
+		"Rename",
+		func() []Stage { return []Stage{&renameFilter{from: "b", to: "c"}} },
+		`<a><!--c--><b>x</b></a>`,
+		`<a><!--c--><c>x</c></a>`,
+	},
+	{
+		"Rename then drop and inject",
+		func() []Stage {
+			return []Stage{
+				&renameFilter{from: "b", to: "c"},
+				&commentFilter{},
+				&renameFilter{from: "t", to: "u"},
+			}
+		},
+		`<a><!--c--><b>x</b></a>`,
+		`<a><c><u>x</u></c></a>`,
+	},
+	{
+		"Nested pipeline",
+		func() []Stage {
+			inner := NewPipeline(nil, &renameFilter{from: "a", to: "b"}, &renameFilter{from: "b", to: "c"})
+			return []Stage{inner, &renameFilter{from: "c", to: "d"}}
+		},
+		`<a/>`,
+		`<d></d>`,
+	},
+}
+
+func TestPipeline(t *testing.T) {
+	for _, v := range pipelineTests {
+		w := new(bytes.Buffer)
+		p := NewPipeline(NewIdentityTransform(w), v.stages()...)
+		if err := Transform(strings.NewReader(v.input), p); err != nil {
+			t.Errorf("%s: %v", v.descr, err)
+			continue
+		}
+		if w.String() != v.output {
+			t.Errorf("%s: expected %s, got %s", v.descr, v.output, w.String())
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Recovery determines how a transform continues after an error for
+// which handler.Error returns false.
+type Recovery int
+
+const (
+	// RecoverContinue passes the next token to the handler.
+	// Errors reported by the decoder cannot be recovered from,
+	// and abort the transform whatever handler.Error returns.
+	RecoverContinue Recovery = iota
+
+	// RecoverSkip is like RecoverContinue, except that when the
+	// handler fails to handle a StartElement the content and
+	// EndElement of that element are not passed to the handler.
+	RecoverSkip
+
+	// RecoverResync is like RecoverSkip, and also recovers from
+	// syntax errors: the input is discarded up to the next '<',
+	// and decoding resumes there within the elements that were
+	// open.  If the input ends while elements are open, the
+	// handler is passed an EndElement for each.
+	RecoverResync
+)
+
+// ErrorList is returned by a transform with CollectErrors set,
+// holding the errors passed to handler.Error in the order they were
+// encountered.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+func (l ErrorList) Unwrap() []error {
+	return l
+}
+
+// recover passes err to the handler, returning the error with which
+// the transform must stop, or nil if it may continue.
+func (t *transformer) recover(err error, recoverable bool) error {
+	abort := t.handler.Error(err) || !recoverable
+	if t.opts.CollectErrors {
+		t.errs = append(t.errs, err)
+	}
+	t.nerrs++
+	if t.opts.MaxErrors > 0 && t.nerrs >= t.opts.MaxErrors {
+		abort = true
+	}
+	if !abort {
+		return nil
+	}
+	if len(t.errs) > 0 {
+		return t.errs
+	}
+	return err
+}
+
+// result returns the errors collected by a transform that ran to
+// completion
+func (t *transformer) result() error {
+	if len(t.errs) > 0 {
+		return t.errs
+	}
+	return nil
+}
+
+// resyncable reports whether the transform can recover from err,
+// reported by the decoder
+func (t *transformer) resyncable(err error) bool {
+	var serr *xml.SyntaxError
+	return t.opts.Recovery == RecoverResync && errors.As(err, &serr)
+}
+
+// openElement records the name and namespace declarations of an
+// element that is open in the input, so that a resynchronized
+// decoder may be put back into the same state
+type openElement struct {
+	name  xml.Name
+	xmlns []xml.Attr
+}
+
+// open records the start of an element when resynchronizing is
+// enabled
+func (t *transformer) open(node xml.StartElement) {
+	if t.opts.Recovery != RecoverResync {
+		return
+	}
+	e := openElement{name: node.Name}
+	for _, attr := range node.Attr {
+		if attr.Name.Space == xmlnsPrefix || (attr.Name.Space == "" && attr.Name.Local == xmlnsPrefix) {
+			e.xmlns = append(e.xmlns, attr)
+		}
+	}
+	t.opened = append(t.opened, e)
+}
+
+// close records the end of an element
+func (t *transformer) close() {
+	if n := len(t.opened); n > 0 {
+		t.opened = t.opened[:n-1]
+	}
+}
+
+// resync discards the input up to the next '<', following a syntax
+// error, and starts a new decoder there.  If the input is exhausted,
+// the open elements are closed and done is returned as true.
+func (t *transformer) resync() (done bool, err error) {
+	in := t.input
+	line, column, offset := t.inputPos()
+
+	// the decoder may hold back the last byte it read
+	if in.n == offset+1 {
+		in.unread()
+	}
+
+	// ensure progress when resynchronizing at the same '<' again
+	if offset == t.resynced {
+		if b, err := in.br.ReadByte(); err == nil {
+			line, column = advance(line, column, b)
+			in.n++
+			offset++
+		}
+	}
+
+	for {
+		var b byte
+		if b, err = in.br.ReadByte(); err != nil {
+			if err != io.EOF {
+				return true, err
+			}
+			return true, t.closeAll()
+		}
+		if b == '<' {
+			in.br.UnreadByte()
+			break
+		}
+		line, column = advance(line, column, b)
+		in.n++
+		offset++
+	}
+
+	t.resynced = offset
+	t.base = Position{Line: line, Column: column, Offset: offset}
+	t.offset = offset
+	in.reset(offset)
+
+	in.prefix = t.reopen()
+	t.prefix = int64(len(in.prefix))
+	t.dec = t.newDecoder(in)
+	for range t.opened {
+		if _, err = t.token(); err != nil {
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+// advance returns the position following b
+func advance(line, column int, b byte) (int, int) {
+	if b == '\n' {
+		return line + 1, 1
+	}
+	return line, column + 1
+}
+
+// reopen renders start tags for the open elements, to be read by a
+// new decoder before the remaining input.  The tags are written
+// without newlines, so that positions on the first line of input
+// can be adjusted by the length of the prefix.
+func (t *transformer) reopen() []byte {
+	var b bytes.Buffer
+	scope := []xml.Attr{{Name: xml.Name{Space: xmlnsPrefix, Local: xmlPrefix}, Value: xmlSpace}}
+	for _, e := range t.opened {
+		scope = append(scope, e.xmlns...)
+		b.WriteByte('<')
+		name := e.name.Local
+		if !t.opts.RawTokens {
+			name = rawName(e.name, scope)
+		} else if e.name.Space != "" {
+			name = e.name.Space + ":" + name
+		}
+		b.WriteString(name)
+		for _, attr := range e.xmlns {
+			b.WriteByte(' ')
+			if attr.Name.Space == xmlnsPrefix {
+				b.WriteString(xmlnsPrefix + ":" + attr.Name.Local)
+			} else {
+				b.WriteString(xmlnsPrefix)
+			}
+			b.WriteString(`="`)
+			xml.EscapeText(&b, []byte(attr.Value))
+			b.WriteByte('"')
+		}
+		b.WriteByte('>')
+	}
+	return b.Bytes()
+}
+
+// rawName returns the prefixed name under which name is likely to
+// have appeared in the input, given the namespace declarations in
+// scope.  A name whose namespace is not declared kept its prefix.
+func rawName(name xml.Name, scope []xml.Attr) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	prefix := name.Space
+	for i := len(scope) - 1; i >= 0; i-- {
+		attr := scope[i]
+		if attr.Value != name.Space {
+			continue
+		}
+		if attr.Name.Space == "" {
+			return name.Local
+		}
+		if shadowed(attr.Name.Local, scope[i+1:]) {
+			continue
+		}
+		prefix = attr.Name.Local
+		break
+	}
+	return prefix + ":" + name.Local
+}
+
+// shadowed reports whether prefix is redeclared in scope
+func shadowed(prefix string, scope []xml.Attr) bool {
+	for _, attr := range scope {
+		if attr.Name.Space == xmlnsPrefix && attr.Name.Local == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// closeAll passes an EndElement to the handler for each open element
+func (t *transformer) closeAll() error {
+	for i := len(t.opened) - 1; i >= 0; i-- {
+		tok := xml.EndElement{Name: t.opened[i].name}
+		t.tok, t.raw = tok, nil
+		if err := t.dispatch(tok); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if err = t.recover(err, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var errBad = errors.New("bad element")
+
+// recoverHandler fails to handle <bad> elements, and asks for every
+// error to be recovered from
+type recoverHandler struct {
+	*IdentityTransform
+	errs int
+}
+
+func (h *recoverHandler) StartElement(node xml.StartElement) error {
+	if node.Name.Local == "bad" {
+		return errBad
+	}
+	return h.IdentityTransform.StartElement(node)
+}
+
+func (h *recoverHandler) EndElement(node xml.EndElement) error {
+	if node.Name.Local == "bad" {
+		return nil
+	}
+	return h.IdentityTransform.EndElement(node)
+}
+
+func (h *recoverHandler) Error(err error) (abort bool) {
+	h.errs++
+	return false
+}
+
+type recoverTest struct {
+	descr    string
+	recovery Recovery
+	input    string
+	output   string
+	errs     int  // errors passed to the handler
+	err      bool // an error is returned
+}
+
+var recoverTests = []recoverTest{
+	{
+		"Continue after handler error",
+		RecoverContinue,
+		`<a><bad><c/></bad><d/></a>`,
+		`<a><c></c><d></d></a>`,
+		1,
+		false,
+	},
+	{
+		"Syntax error is fatal",
+		RecoverContinue,
+		`<a><b></c><d/></a>`,
+		`<a><b>`,
+		1,
+		true,
+	},
+	{
+		"Skip subtree",
+		RecoverSkip,
+		`<a><bad><c/><bad/></bad><d/></a>`,
+		`<a><d></d></a>`,
+		1,
+		false,
+	},
+	{
+		"Syntax error is fatal when skipping",
+		RecoverSkip,
+		`<a><b>&bogus;</b></a>`,
+		`<a><b>`,
+		1,
+		true,
+	},
+	{
+		"Resync on mismatched end tag",
+		RecoverResync,
+		`<a><b>x</c></b><d/></a>`,
+		`<a><b>x</b><d></d></a>`,
+		1,
+		false,
+	},
+	{
+		"Resync on undefined entity",
+		RecoverResync,
+		`<x:a xmlns:x="urn:x"><x:b>&bogus; skipped</x:b><x:c/></x:a>`,
+		`<x:a xmlns:x='urn:x'><x:b></x:b><x:c></x:c></x:a>`,
+		1,
+		false,
+	},
+	{
+		"Resync closes elements at EOF",
+		RecoverResync,
+		`<a><b>text`,
+		`<a><b>text</b></a>`,
+		1,
+		false,
+	},
+	{
+		"Resync skips subtree",
+		RecoverResync,
+		`<a><bad><c/></bad><b>x</c><d/></b></a>`,
+		`<a><b>x<d></d></b></a>`,
+		2,
+		false,
+	},
+	{
+		"Resync on stray markup",
+		RecoverResync,
+		`<a>x < y <b/></a>`,
+		`<a>x <b></b></a>`,
+		1,
+		false,
+	},
+	{
+		"Resync in declared encoding",
+		RecoverResync,
+		"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><a>\xe9</c>\xe9<b>\xe9</b></a>",
+		"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><a>é<b>é</b></a>",
+		1,
+		false,
+	},
+}
+
+func TestRecover(t *testing.T) {
+	for _, v := range recoverTests {
+		w := new(bytes.Buffer)
+		h := &recoverHandler{IdentityTransform: NewIdentityTransform(w)}
+		opts := NewTransformOptions()
+		opts.Recovery = v.recovery
+		err := TransformWithOptions(strings.NewReader(v.input), h, opts)
+		if v.err && err == nil {
+			t.Errorf("%s: expected an error", v.descr)
+		} else if !v.err && err != nil {
+			t.Errorf("%s: %v", v.descr, err)
+		}
+		if h.errs != v.errs {
+			t.Errorf("%s: expected %d errors, got %d", v.descr, v.errs, h.errs)
+		}
+		if w.String() != v.output {
+			t.Errorf("%s: expected %s, got %s", v.descr, v.output, w.String())
+		}
+	}
+}
+
+// positionHandler records the position of each start element, and
+// asks for every error to be recovered from
+type positionHandler struct {
+	*IdentityTransform
+	loc  Locator
+	seen []string
+}
+
+func (h *positionHandler) SetLocator(loc Locator) {
+	h.loc = loc
+}
+
+func (h *positionHandler) StartElement(node xml.StartElement) error {
+	pos := h.loc.Position()
+	h.seen = append(h.seen, fmt.Sprintf("%s %d:%d@%d", h.loc.Path(), pos.Line, pos.Column, pos.Offset))
+	return h.IdentityTransform.StartElement(node)
+}
+
+func (h *positionHandler) Error(err error) (abort bool) {
+	return false
+}
+
+func TestResyncPosition(t *testing.T) {
+	h := &positionHandler{IdentityTransform: NewIdentityTransform(new(bytes.Buffer))}
+	opts := NewTransformOptions()
+	opts.Recovery = RecoverResync
+	opts.CollectErrors = true
+
+	input := "<a>\n<b>x</c>\n  <d/>\n<e/></b></a>"
+	err := TransformWithOptions(strings.NewReader(input), h, opts)
+
+	expected := []string{
+		"/a 1:1@0",
+		"/a/b 2:1@4",
+		"/a/b/d 3:3@15",
+		"/a/b/e 4:1@20",
+	}
+	if strings.Join(h.seen, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(h.seen, "\n"))
+	}
+
+	var list ErrorList
+	if !errors.As(err, &list) || len(list) != 1 {
+		t.Fatalf("expected one error, got %v", err)
+	}
+	var terr *TransformError
+	if !errors.As(list[0], &terr) || terr.Line != 2 || terr.Path != "/a/b" {
+		t.Errorf("unexpected error: %v", list[0])
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	input := `<a>` + strings.Repeat(`<bad/><b/>`, 5) + `</a>`
+
+	opts := NewTransformOptions()
+	opts.CollectErrors = true
+	w := new(bytes.Buffer)
+	err := TransformWithOptions(strings.NewReader(input), &recoverHandler{IdentityTransform: NewIdentityTransform(w)}, opts)
+	var list ErrorList
+	if !errors.As(err, &list) {
+		t.Fatalf("expected an ErrorList, got %v", err)
+	}
+	if len(list) != 5 || !errors.Is(err, errBad) {
+		t.Errorf("expected 5 errors, got %v", list)
+	}
+	if expected := `<a>` + strings.Repeat(`<b></b>`, 5) + `</a>`; w.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+
+	opts.MaxErrors = 3
+	w.Reset()
+	err = TransformWithOptions(strings.NewReader(input), &recoverHandler{IdentityTransform: NewIdentityTransform(w)}, opts)
+	if !errors.As(err, &list) || len(list) != 3 {
+		t.Errorf("expected 3 errors, got %v", err)
+	}
+	if expected := `<a><b></b><b></b>`; w.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+
+	opts.CollectErrors = false
+	err = TransformWithOptions(strings.NewReader(input), &recoverHandler{IdentityTransform: NewIdentityTransform(w)}, opts)
+	var terr *TransformError
+	if !errors.As(err, &terr) || terr.Err != errBad {
+		t.Errorf("expected the third error, got %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package transform
+
+import (
+	"encoding/xml"
+
+	"github.com/jimrobinson/xml/xmlpath"
+)
+
+// StartFunc, EndFunc and TextFunc handle the events routed to them by
+// a Router.  Like a TokenHandler, they pass tokens downstream by
+// calling out.Emit; an event that is not emitted is dropped.
+type StartFunc func(node xml.StartElement, out Emitter) error
+type EndFunc func(node xml.EndElement, out Emitter) error
+type TextFunc func(node xml.CharData, out Emitter) error
+
+// Router implements a Stage that passes events to the functions
+// registered against the path patterns that they match, in place of
+// a switch on element names.  Events that match no pattern pass
+// through to the Next handler unchanged.
+//
+// Start and End functions are called for the elements a pattern
+// matches, Text functions for the character data directly within
+// them, and Capture functions for the whole of each element.  When
+// more than one pattern matches an event, the function registered
+// first is called.  Patterns are compiled by
+// xmlpath.Compile with the namespaces given to NewRouter.
+//
+// Raw must be set when the transform is run with
+// TransformOptions.RawTokens.  Transform sets it by calling SetOptions
+// on a Router that it reaches directly or through a Pipeline.
+type Router struct {
+	Filter
+	Raw bool
+
+	skip   bool // the content of an element whose start fails is skipped
+	path   *xmlpath.XmlPath
+	ns     map[string]string
+	starts []startRoute
+	ends   []endRoute
+	texts  []textRoute
+
+	captures []captureRoute
+	open     []*Node     // elements of the subtree being captured
+	captured CaptureFunc // function receiving the subtree being captured
+}
+
+type startRoute struct {
+	m *xmlpath.Match
+	f StartFunc
+}
+
+type endRoute struct {
+	m *xmlpath.Match
+	f EndFunc
+}
+
+type textRoute struct {
+	m *xmlpath.Match
+	f TextFunc
+}
+
+// NewRouter returns a Router passing unrouted events to next, which
+// is typically an IdentityTransform.  The prefixes used in patterns
+// are mapped to namespace uris by namespaces.
+func NewRouter(next Handler, namespaces map[string]string) *Router {
+	return &Router{
+		Filter: Filter{Next: next},
+		path:   xmlpath.NewXmlPath(),
+		ns:     namespaces,
+	}
+}
+
+// Path returns the path of the current element
+func (r *Router) Path() *xmlpath.XmlPath {
+	return r.path
+}
+
+// SetOptions sets Raw from opts.RawTokens, notes whether the content
+// of an element whose StartElement fails is skipped, and passes opts
+// on to the Next handler.
+func (r *Router) SetOptions(opts *TransformOptions) {
+	r.Raw = opts.RawTokens
+	r.skip = opts.Recovery >= RecoverSkip
+	r.Filter.SetOptions(opts)
+}
+
+func (r *Router) watch(pattern string) (*xmlpath.Match, error) {
+	p, err := xmlpath.Compile(pattern, r.ns)
+	if err != nil {
+		return nil, err
+	}
+	return r.path.Watch(p), nil
+}
+
+// Start routes the StartElement of the elements matching pattern to f
+func (r *Router) Start(pattern string, f StartFunc) error {
+	m, err := r.watch(pattern)
+	if err == nil {
+		r.starts = append(r.starts, startRoute{m, f})
+	}
+	return err
+}
+
+// End routes the EndElement of the elements matching pattern to f
+func (r *Router) End(pattern string, f EndFunc) error {
+	m, err := r.watch(pattern)
+	if err == nil {
+		r.ends = append(r.ends, endRoute{m, f})
+	}
+	return err
+}
+
+// Text routes the character data directly within the elements
+// matching pattern to f.  CDATA sections are passed as xml.CharData.
+func (r *Router) Text(pattern string, f TextFunc) error {
+	m, err := r.watch(pattern)
+	if err == nil {
+		r.texts = append(r.texts, textRoute{m, f})
+	}
+	return err
+}
+
+// StartElement pushes node onto the path before routing it.  If
+// handling node fails it is popped again when the transform is run
+// with RecoverSkip or RecoverResync, as its content and EndElement
+// are skipped.  With RecoverContinue it remains the current element
+// until its EndElement.
+func (r *Router) StartElement(node xml.StartElement) (err error) {
+	if r.Raw {
+		r.path.PushRaw(node)
+	} else {
+		r.path.Push(node)
+	}
+
+	if err = r.start(node); err != nil && r.skip {
+		r.path.Pop()
+	}
+	return
+}
+
+func (r *Router) start(node xml.StartElement) error {
+	if r.capturing() {
+		r.startNode(node)
+		return nil
+	}
+	if r.capture(node) {
+		return nil
+	}
+	for _, route := range r.starts {
+		if route.m.Matched() {
+			return route.f(node, &r.Filter)
+		}
+	}
+	return r.Filter.StartElement(node)
+}
+
+// EndElement routes node, and then pops it from the path
+func (r *Router) EndElement(node xml.EndElement) (err error) {
+	if r.path.Depth() == 0 {
+		return r.Filter.EndElement(node)
+	}
+	defer r.path.Pop()
+	if r.capturing() {
+		return r.endNode()
+	}
+	for _, route := range r.ends {
+		if route.m.Matched() {
+			return route.f(node, &r.Filter)
+		}
+	}
+	return r.Filter.EndElement(node)
+}
+
+func (r *Router) CharData(node xml.CharData) error {
+	if r.capturing() {
+		return r.add(node)
+	}
+	if f := r.text(); f != nil {
+		return f(node, &r.Filter)
+	}
+	return r.Filter.CharData(node)
+}
+
+func (r *Router) CData(node CData) error {
+	if r.capturing() {
+		return r.add(node)
+	}
+	if f := r.text(); f != nil {
+		return f(xml.CharData(node), &r.Filter)
+	}
+	return r.Emit(node)
+}
+
+func (r *Router) Comment(node xml.Comment) error {
+	if r.capturing() {
+		return r.add(node)
+	}
+	return r.Filter.Comment(node)
+}
+
+func (r *Router) Directive(node xml.Directive) error {
+	if r.capturing() {
+		return r.add(node)
+	}
+	return r.Filter.Directive(node)
+}
+
+func (r *Router) ProcInst(node xml.ProcInst) error {
+	if r.capturing() {
+		return r.add(node)
+	}
+	return r.Filter.ProcInst(node)
+}
+
+// text returns the function handling the character data of the
+// current element, or nil
+func (r *Router) text() TextFunc {
+	for _, route := range r.texts {
+		if route.m.Matched() {
+			return route.f
+		}
+	}
+	return nil
+}
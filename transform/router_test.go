@@ -0,0 +1,223 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/jimrobinson/xml/xmlpath"
+)
+
+const routerInput = `<feed xmlns="http://www.w3.org/2005/Atom" xmlns:h="http://www.w3.org/1999/xhtml">` +
+	`<title>feed</title>` +
+	`<entry><title>one</title><link href="1"/><content><h:p>x<h:img src="a.gif"/></h:p></content></entry>` +
+	`<entry><title>two</title><link href="2"/></entry>` +
+	`</feed>`
+
+// newTestRouter returns a Router that resolves entry links, drops
+// images, upper cases titles and marks the end of each entry
+func newTestRouter(t *testing.T, next Handler) *Router {
+	r := NewRouter(next, map[string]string{
+		"atom":  "http://www.w3.org/2005/Atom",
+		"xhtml": xhtmlSpace,
+	})
+	routes := []error{
+		r.Start("/atom:feed/atom:entry/atom:link", func(node xml.StartElement, out Emitter) error {
+			for i, attr := range node.Attr {
+				if attr.Name.Local == "href" {
+					node.Attr[i].Value = "http://example.com/" + attr.Value
+				}
+			}
+			return out.Emit(node)
+		}),
+		r.Start("//xhtml:img", func(node xml.StartElement, out Emitter) error {
+			return nil
+		}),
+		r.End("//xhtml:img", func(node xml.EndElement, out Emitter) error {
+			return nil
+		}),
+		r.Text("atom:entry/atom:title", func(node xml.CharData, out Emitter) error {
+			return out.Emit(xml.CharData(bytes.ToUpper(node)))
+		}),
+		r.End("atom:entry", func(node xml.EndElement, out Emitter) error {
+			if err := out.Emit(xml.Comment(r.Path().Format(xmlpath.Format{Positions: true}))); err != nil {
+				return err
+			}
+			return out.Emit(node)
+		}),
+	}
+	for _, err := range routes {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestRouter(t *testing.T) {
+	for _, raw := range []bool{false, true} {
+		w := new(bytes.Buffer)
+		sink := NewIdentityTransform(w)
+		sink.Raw = raw
+		r := newTestRouter(t, nil)
+
+		opts := NewTransformOptions()
+		opts.RawTokens = raw
+		if err := TransformWithOptions(strings.NewReader(routerInput), NewPipeline(sink, r), opts); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := `<feed xmlns='http://www.w3.org/2005/Atom' xmlns:h='http://www.w3.org/1999/xhtml'>` +
+			`<title>feed</title>` +
+			`<entry><title>ONE</title><link href='http://example.com/1'></link><content><h:p>x</h:p></content><!--/feed[1]/entry[1]--></entry>` +
+			`<entry><title>TWO</title><link href='http://example.com/2'></link><!--/feed[1]/entry[2]--></entry>` +
+			`</feed>`
+		if w.String() != expected {
+			t.Errorf("raw %v: expected\n%s\ngot\n%s", raw, expected, w.String())
+		}
+		if d := r.Path().Depth(); d != 0 {
+			t.Errorf("raw %v: expected an empty path, got depth %d", raw, d)
+		}
+	}
+}
+
+func TestRouterFailedStart(t *testing.T) {
+	tests := []struct {
+		recovery Recovery
+		output   string
+	}{
+		{RecoverContinue, `<a><c></c><d></d><!--end--></a>`},
+		{RecoverSkip, `<a><d></d><!--end--></a>`},
+	}
+	for _, v := range tests {
+		w := new(bytes.Buffer)
+		r := NewRouter(&recoverHandler{IdentityTransform: NewIdentityTransform(w)}, nil)
+		routes := []error{
+			r.Start("/a/a", func(node xml.StartElement, out Emitter) error {
+				return errBad
+			}),
+			r.End("/a/a", func(node xml.EndElement, out Emitter) error {
+				return nil
+			}),
+			r.End("/a", func(node xml.EndElement, out Emitter) error {
+				if err := out.Emit(xml.Comment("end")); err != nil {
+					return err
+				}
+				return out.Emit(node)
+			}),
+		}
+		for _, err := range routes {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		opts := NewTransformOptions()
+		opts.Recovery = v.recovery
+		if err := TransformWithOptions(strings.NewReader(`<a><a><c/></a><d/></a>`), r, opts); err != nil {
+			t.Fatal(err)
+		}
+		if w.String() != v.output {
+			t.Errorf("recovery %d: expected %s, got %s", v.recovery, v.output, w.String())
+		}
+		if d := r.Path().Depth(); d != 0 {
+			t.Errorf("recovery %d: expected an empty path, got depth %d", v.recovery, d)
+		}
+	}
+}
+
+func TestRouterPattern(t *testing.T) {
+	r := NewRouter(nil, nil)
+	if err := r.Start("x:a", func(node xml.StartElement, out Emitter) error { return nil }); err == nil {
+		t.Errorf("expected an error for an unmapped prefix")
+	}
+}
+
+func TestRouterCapture(t *testing.T) {
+	const atomSpace = "http://www.w3.org/2005/Atom"
+	input := `<feed xmlns="http://www.w3.org/2005/Atom"><title>feed</title>` +
+		`<entry><link href="a"/><id>urn:1</id><!--c--><content>x<![CDATA[y]]></content></entry>` +
+		`<entry><id>urn:2</id><link href="b"/><link href="c"/></entry>` +
+		`<entry><title>drop</title></entry>` +
+		`</feed>`
+
+	for _, raw := range []bool{false, true} {
+		w := new(bytes.Buffer)
+		sink := NewIdentityTransform(w)
+		sink.Raw = raw
+		r := NewRouter(sink, map[string]string{"atom": atomSpace})
+
+		links := xmlpath.MustCompile("/atom:entry/atom:link", map[string]string{"atom": atomSpace})
+		ids := xmlpath.MustCompile("atom:id", map[string]string{"atom": atomSpace})
+		var texts []string
+		err := r.Capture("/atom:feed/atom:entry", func(n *Node, out Emitter) error {
+			texts = append(texts, n.Text())
+			selected := n.Select(ids)
+			if len(selected) == 0 {
+				return nil
+			}
+			id := selected[0].Text()
+			for _, link := range n.Select(links) {
+				href, _ := link.AttrValue(xml.Name{Local: "href"})
+				link.SetAttr(xml.Name{Local: "href"}, id+"/"+href)
+			}
+			n.SetAttr(xml.Name{Local: "seen"}, "true")
+			return n.Emit(out)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Start("atom:title", func(node xml.StartElement, out Emitter) error {
+			node.Attr = append(node.Attr, xml.Attr{Name: xml.Name{Local: "routed"}, Value: "true"})
+			return out.Emit(node)
+		})
+
+		opts := NewTransformOptions()
+		opts.RawTokens = raw
+		opts.CDATA = true
+		if err = TransformWithOptions(strings.NewReader(input), r, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := `<feed xmlns='http://www.w3.org/2005/Atom'><title routed='true'>feed</title>` +
+			`<entry seen='true'><link href='urn:1/a'></link><id>urn:1</id><!--c--><content>x<![CDATA[y]]></content></entry>` +
+			`<entry seen='true'><id>urn:2</id><link href='urn:2/b'></link><link href='urn:2/c'></link></entry>` +
+			`</feed>`
+		if w.String() != expected {
+			t.Errorf("raw %v: expected\n%s\ngot\n%s", raw, expected, w.String())
+		}
+		if s := strings.Join(texts, ","); s != "urn:1xy,urn:2,drop" {
+			t.Errorf("raw %v: unexpected text %s", raw, s)
+		}
+	}
+}
+
+func TestNode(t *testing.T) {
+	n := NewNode(xml.StartElement{Name: xml.Name{Local: "a"}})
+	b := NewNode(xml.StartElement{Name: xml.Name{Local: "b"}})
+	n.Append(xml.CharData("x"), b, xml.Comment("c"))
+	b.Append(xml.CharData("y"))
+
+	if n.Element(xml.Name{Local: "b"}) != b || n.Element(xml.Name{Local: "c"}) != nil {
+		t.Errorf("unexpected Element result")
+	}
+	if len(n.Elements()) != 1 || n.Text() != "xy" {
+		t.Errorf("unexpected content %v", n.Children)
+	}
+	if sel := n.Select(xmlpath.MustCompile("//b", nil)); len(sel) != 1 || sel[0] != b {
+		t.Errorf("unexpected selection %v", sel)
+	}
+
+	w := new(bytes.Buffer)
+	h := NewIdentityTransform(w)
+	if err := n.Emit(h); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `<a>x<b>y</b><!--c--></a>`; w.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+}
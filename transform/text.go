@@ -24,15 +24,17 @@ const (
 )
 
 var (
-	esc_quot = []byte("&#34;") // shorter than "&quot;"
-	esc_apos = []byte("&#39;") // shorter than "&apos;"
-	esc_amp  = []byte("&amp;")
-	esc_lt   = []byte("&lt;")
-	esc_gt   = []byte("&gt;")
-	esc_tab  = []byte("&#x9;")
-	esc_nl   = []byte("&#xA;")
-	esc_cr   = []byte("&#xD;")
-	esc_fffd = []byte("\uFFFD") // Unicode replacement character
+	esc_quot       = []byte("&#34;") // shorter than "&quot;"
+	esc_apos       = []byte("&#39;") // shorter than "&apos;"
+	esc_quot_named = []byte("&quot;")
+	esc_apos_named = []byte("&apos;")
+	esc_amp        = []byte("&amp;")
+	esc_lt         = []byte("&lt;")
+	esc_gt         = []byte("&gt;")
+	esc_tab        = []byte("&#x9;")
+	esc_nl         = []byte("&#xA;")
+	esc_cr         = []byte("&#xD;")
+	esc_fffd       = []byte("\uFFFD") // Unicode replacement character
 )
 
 // Decide whether the given rune is in the XML Character Range, per
@@ -47,78 +49,156 @@ func isInCharacterRange(r rune) (inrange bool) {
 		r >= 0x10000 && r <= 0x10FFFF
 }
 
+// EscapePolicy controls the escaping of attribute values and
+// character data.  The zero value escapes both quote characters in
+// attribute values using numeric character references, and escapes
+// '>' in character data.
+type EscapePolicy struct {
+	// Quote is the character used to delimit attribute values,
+	// either '\'' or '"'.  If zero, '\'' is used.
+	Quote byte
+
+	// MinimalQuotes limits the escaping of quote characters in
+	// attribute values to the Quote character.
+	MinimalQuotes bool
+
+	// NamedEntities escapes quote characters as &quot; and &apos;
+	// rather than the shorter &#34; and &#39;
+	NamedEntities bool
+
+	// KeepGT leaves '>' unescaped in character data, unless it
+	// follows "]]", as "]]>" may not appear in character data
+	KeepGT bool
+}
+
+// QuoteChar returns the character used to delimit attribute values
+func (p *EscapePolicy) QuoteChar() byte {
+	if p.Quote == '"' {
+		return '"'
+	}
+	return '\''
+}
+
 // EscapeNodeValue writes to w the properly escaped XML equivalent of
 // the plain text data s for node type t.
 func EscapeNodeValue(w io.Writer, s []byte, t NodeType) error {
-	var esc []byte
+	var p EscapePolicy
+	return p.Escape(w, s, t)
+}
+
+// Escape writes to w the XML equivalent of the plain text data s for
+// node type t, escaped according to the policy.
+func (p *EscapePolicy) Escape(w io.Writer, s []byte, t NodeType) error {
+	var brackets int
+	return p.escapeText(w, s, t, &brackets)
+}
+
+// escapeText is like Escape.  brackets counts the consecutive ']'
+// characters that precede s, and is updated to count those that end
+// it, so that "]]>" is escaped when split across calls.
+func (p *EscapePolicy) escapeText(w io.Writer, s []byte, t NodeType, brackets *int) error {
 	last := 0
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRune(s[i:])
+		i += width
+		esc := p.escape(r, t, *brackets >= 2)
+		if r == ']' {
+			*brackets++
+		} else {
+			*brackets = 0
+		}
+		if esc == nil {
+			continue
+		}
+		if _, err := w.Write(s[last : i-width]); err != nil {
+			return err
+		}
+		if _, err := w.Write(esc); err != nil {
+			return err
+		}
+		last = i
+	}
+	if _, err := w.Write(s[last:]); err != nil {
+		return err
+	}
+	return nil
+}
+
+// EscapeString is like Escape, but escapes the string s, avoiding
+// the conversion of s to a byte slice.
+func (p *EscapePolicy) EscapeString(w io.Writer, s string, t NodeType) error {
+	last, brackets := 0, 0
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRuneInString(s[i:])
+		i += width
+		esc := p.escape(r, t, brackets >= 2)
+		if r == ']' {
+			brackets++
+		} else {
+			brackets = 0
+		}
+		if esc == nil {
+			continue
+		}
+		if _, err := io.WriteString(w, s[last:i-width]); err != nil {
+			return err
+		}
+		if _, err := w.Write(esc); err != nil {
+			return err
+		}
+		last = i
+	}
+	if _, err := io.WriteString(w, s[last:]); err != nil {
+		return err
+	}
+	return nil
+}
+
+// escape returns the escaped form of r for node type t, or nil if r
+// may be written as is.  cdataEnd reports whether r follows "]]", in
+// which case '>' is escaped regardless of KeepGT.
+func (p *EscapePolicy) escape(r rune, t NodeType, cdataEnd bool) []byte {
+	switch r {
+	case '&':
+		return esc_amp
+	case '<':
+		return esc_lt
+	case '\r':
+		return esc_cr
+	}
 
 	switch t {
 	case AttrValue:
-		for i := 0; i < len(s); {
-			r, width := utf8.DecodeRune(s[i:])
-			i += width
-			switch r {
-			case '"':
-				esc = esc_quot
-			case '\'':
-				esc = esc_apos
-			case '&':
-				esc = esc_amp
-			case '<':
-				esc = esc_lt
-			case '\t':
-				esc = esc_tab
-			case '\n':
-				esc = esc_nl
-			case '\r':
-				esc = esc_cr
-			default:
-				if !isInCharacterRange(r) {
-					esc = esc_fffd
-					break
-				}
-				continue
+		switch r {
+		case '"':
+			if p.MinimalQuotes && p.QuoteChar() != '"' {
+				return nil
 			}
-			if _, err := w.Write(s[last : i-width]); err != nil {
-				return err
+			if p.NamedEntities {
+				return esc_quot_named
 			}
-			if _, err := w.Write(esc); err != nil {
-				return err
+			return esc_quot
+		case '\'':
+			if p.MinimalQuotes && p.QuoteChar() != '\'' {
+				return nil
 			}
-			last = i
+			if p.NamedEntities {
+				return esc_apos_named
+			}
+			return esc_apos
+		case '\t':
+			return esc_tab
+		case '\n':
+			return esc_nl
 		}
 	case CharData:
-		for i := 0; i < len(s); {
-			r, width := utf8.DecodeRune(s[i:])
-			i += width
-			switch r {
-			case '&':
-				esc = esc_amp
-			case '<':
-				esc = esc_lt
-			case '>':
-				esc = esc_gt
-			case '\r':
-				esc = esc_cr
-			default:
-				if !isInCharacterRange(r) {
-					esc = esc_fffd
-					break
-				}
-				continue
-			}
-			if _, err := w.Write(s[last : i-width]); err != nil {
-				return err
-			}
-			if _, err := w.Write(esc); err != nil {
-				return err
-			}
-			last = i
+		if r == '>' && (!p.KeepGT || cdataEnd) {
+			return esc_gt
 		}
 	}
-	if _, err := w.Write(s[last:]); err != nil {
-		return err
+
+	if !isInCharacterRange(r) {
+		return esc_fffd
 	}
 	return nil
 }
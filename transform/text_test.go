@@ -63,3 +63,34 @@ func TestEscapeNodeValue(t *testing.T) {
 		}
 	}
 }
+
+type policyTest struct {
+	policy   EscapePolicy
+	nodeType NodeType
+	input    string
+	output   string
+}
+
+var escapePolicyTests = []policyTest{
+	{EscapePolicy{}, AttrValue, `'"`, `&#39;&#34;`},
+	{EscapePolicy{NamedEntities: true}, AttrValue, `'"`, `&apos;&quot;`},
+	{EscapePolicy{MinimalQuotes: true}, AttrValue, `'"`, `&#39;"`},
+	{EscapePolicy{Quote: '"', MinimalQuotes: true}, AttrValue, `'"`, `'&#34;`},
+	{EscapePolicy{Quote: '"', MinimalQuotes: true, NamedEntities: true}, AttrValue, `'"&<>`, `'&quot;&amp;&lt;>`},
+	{EscapePolicy{}, CharData, `'"&<>`, `'"&amp;&lt;&gt;`},
+	{EscapePolicy{KeepGT: true}, CharData, `'"&<>`, `'"&amp;&lt;>`},
+	{EscapePolicy{KeepGT: true}, CharData, `x]]>y]>z]]]>`, `x]]&gt;y]>z]]]&gt;`},
+	{EscapePolicy{KeepGT: true}, AttrValue, `]]>`, `]]>`},
+}
+
+func TestEscapePolicy(t *testing.T) {
+	for i, v := range escapePolicyTests {
+		w := &bytes.Buffer{}
+		if err := v.policy.Escape(w, []byte(v.input), v.nodeType); err != nil {
+			t.Fatal(i, err)
+		}
+		if w.String() != v.output {
+			t.Errorf("%d: expected %s, got %s", i, v.output, w.String())
+		}
+	}
+}
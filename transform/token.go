@@ -0,0 +1,101 @@
+package transform
+
+import (
+	"encoding/xml"
+)
+
+// Emitter receives a stream of XML tokens.  Filter and
+// IdentityTransform both implement Emitter, so a TokenHandler may
+// emit its output to the next stage of a Pipeline or directly to a
+// serializer.
+type Emitter interface {
+	Emit(xml.Token) error
+}
+
+// TokenHandler is an alternative to the Handler contract for stages
+// that need to emit zero, one, or many tokens for each token they
+// receive, e.g., to replace an element with a subtree, wrap or unwrap
+// elements, or insert new elements.
+//
+// HandleToken is called with each token reported by the parser.  It
+// passes tokens downstream by calling out.Emit; a token that is not
+// emitted is dropped.  The data referenced by a token is only valid
+// until HandleToken returns, so a TokenHandler that holds on to
+// tokens must copy them with xml.CopyToken.
+type TokenHandler interface {
+	HandleToken(tok xml.Token, out Emitter) error
+}
+
+// TokenHandlerFunc adapts an ordinary function to a TokenHandler
+type TokenHandlerFunc func(tok xml.Token, out Emitter) error
+
+func (f TokenHandlerFunc) HandleToken(tok xml.Token, out Emitter) error {
+	return f(tok, out)
+}
+
+// TokenStage implements a Stage that passes each event to a
+// TokenHandler, which emits its output to the next Handler.
+type TokenStage struct {
+	Filter
+
+	// CDATA, if true, causes CDATA sections to be passed to the
+	// TokenHandler as CData tokens.  Otherwise they are passed as
+	// xml.CharData, so that a TokenHandler examining character
+	// data sees them.
+	CDATA bool
+
+	h TokenHandler
+}
+
+func NewTokenStage(h TokenHandler) *TokenStage {
+	return &TokenStage{h: h}
+}
+
+// SetLocator passes loc on to the TokenHandler, if it is a
+// LocatorSetter, and to the next Handler.
+func (s *TokenStage) SetLocator(loc Locator) {
+	if ls, ok := s.h.(LocatorSetter); ok {
+		ls.SetLocator(loc)
+	}
+	s.Filter.SetLocator(loc)
+}
+
+// SetOptions passes opts on to the TokenHandler, if it is an
+// OptionsSetter, and to the next Handler.
+func (s *TokenStage) SetOptions(opts *TransformOptions) {
+	if setter, ok := s.h.(OptionsSetter); ok {
+		setter.SetOptions(opts)
+	}
+	s.Filter.SetOptions(opts)
+}
+
+func (s *TokenStage) StartElement(node xml.StartElement) error {
+	return s.h.HandleToken(node, &s.Filter)
+}
+
+func (s *TokenStage) EndElement(node xml.EndElement) error {
+	return s.h.HandleToken(node, &s.Filter)
+}
+
+func (s *TokenStage) CharData(node xml.CharData) error {
+	return s.h.HandleToken(node, &s.Filter)
+}
+
+func (s *TokenStage) CData(node CData) error {
+	if !s.CDATA {
+		return s.h.HandleToken(xml.CharData(node), &s.Filter)
+	}
+	return s.h.HandleToken(node, &s.Filter)
+}
+
+func (s *TokenStage) Comment(node xml.Comment) error {
+	return s.h.HandleToken(node, &s.Filter)
+}
+
+func (s *TokenStage) Directive(node xml.Directive) error {
+	return s.h.HandleToken(node, &s.Filter)
+}
+
+func (s *TokenStage) ProcInst(node xml.ProcInst) error {
+	return s.h.HandleToken(node, &s.Filter)
+}
@@ -0,0 +1,110 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// unwrap drops the start and end tags of <span> elements, keeping
+// their content
+var unwrap = TokenHandlerFunc(func(tok xml.Token, out Emitter) error {
+	switch node := tok.(type) {
+	case xml.StartElement:
+		if node.Name.Local == "span" {
+			return nil
+		}
+	case xml.EndElement:
+		if node.Name.Local == "span" {
+			return nil
+		}
+	}
+	return out.Emit(tok)
+})
+
+// expand replaces <img> elements with a <figure> subtree
+var expand = TokenHandlerFunc(func(tok xml.Token, out Emitter) (err error) {
+	switch node := tok.(type) {
+	case xml.StartElement:
+		if node.Name.Local == "img" {
+			toks := []xml.Token{
+				xml.StartElement{Name: xml.Name{Local: "figure"}},
+				node,
+				xml.EndElement{Name: node.Name},
+				xml.StartElement{Name: xml.Name{Local: "figcaption"}},
+				xml.CharData("caption"),
+				xml.EndElement{Name: xml.Name{Local: "figcaption"}},
+			}
+			for _, tok := range toks {
+				if err = out.Emit(tok); err != nil {
+					return
+				}
+			}
+			return
+		}
+	case xml.EndElement:
+		if node.Name.Local == "img" {
+			return out.Emit(xml.EndElement{Name: xml.Name{Local: "figure"}})
+		}
+	}
+	return out.Emit(tok)
+})
+
+type tokenStageTest struct {
+	descr   string
+	handler TokenHandler
+	input   string
+	output  string
+}
+
+var tokenStageTests = []tokenStageTest{
+	{
+		"Unwrap",
+		unwrap,
+		`<p>a <span>b <span>c</span></span> d</p>`,
+		`<p>a b c d</p>`,
+	},
+	{
+		"Expand",
+		expand,
+		`<p><img src="x"/></p>`,
+		`<p><figure><img src='x'></img><figcaption>caption</figcaption></figure></p>`,
+	},
+}
+
+func TestTokenStage(t *testing.T) {
+	for _, v := range tokenStageTests {
+		w := new(bytes.Buffer)
+		p := NewPipeline(NewIdentityTransform(w), NewTokenStage(v.handler))
+		if err := Transform(strings.NewReader(v.input), p); err != nil {
+			t.Errorf("%s: %v", v.descr, err)
+			continue
+		}
+		if w.String() != v.output {
+			t.Errorf("%s: expected %s, got %s", v.descr, v.output, w.String())
+		}
+	}
+}
+
+func TestIdentityEmit(t *testing.T) {
+	w := new(bytes.Buffer)
+	dec := xml.NewDecoder(strings.NewReader(`<a b="c">d<!--e--></a>`))
+	sink := NewIdentityTransform(w)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if err = unwrap.HandleToken(tok, sink); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<a b='c'>d<!--e--></a>`
+	if w.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+}
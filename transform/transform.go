@@ -1,54 +1,432 @@
 package transform
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"io"
 	"fmt"
+	"github.com/jimrobinson/xml/xmlpath"
 )
 
 // Transform iterates over an XML document passed in via r, calling
-// the provided handler for each parsed node.
+// the provided handler for each parsed node.  The document is decoded
+// using the default TransformOptions.
 //
 // Any non io.EOF error encountered during the parsing or handling
 // stages will be passed to the handler.Error method.  If the
 // handler.Error method returns true, then processing will be aborted
-// and the error returned.
+// and the error returned.  Otherwise the transform recovers from the
+// error as directed by TransformOptions.Recovery.
 //
-// handler.Flush will be called before Transform returns.
+// handler.Flush will be called before Transform returns, and its
+// error returned if no other error was encountered.
+//
+// A handler embedding a *BaseHandler is passed through Track, and a
+// Canonicalizer is passed raw tokens.  See BaseHandler and
+// Canonicalizer.
 func Transform(r io.Reader, handler Handler) (err error) {
-	defer handler.Flush()
+	return TransformWithOptions(r, handler, nil)
+}
+
+// TransformOptions configure the decoding of a document by
+// TransformWithOptions.  The zero value is not the default; use
+// NewTransformOptions and modify the result.
+type TransformOptions struct {
+	// CharsetReader, if not nil, is used to convert a document
+	// declaring an encoding other than UTF-8 to UTF-8.  See
+	// xml.Decoder.CharsetReader.
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+	// Strict, AutoClose and Entity configure the decoder's
+	// handling of malformed input and of entities.  See the
+	// xml.Decoder fields of the same names.
+	Strict    bool
+	AutoClose []string
+	Entity    map[string]string
+
+	// MaxDepth, MaxAttrs, MaxTokenSize, MaxBytes and MaxTokens, if
+	// greater than zero, limit the element nesting depth, the
+	// number of attributes on an element, the number of bytes of
+	// input consumed by a single token, the total number of bytes
+	// of input, and the total number of tokens.  A limit that is
+	// exceeded aborts the transform with a *LimitError.
+	//
+	// MaxBytes is enforced as the input is read, and so bounds the
+	// memory used by the decoder, along with MaxEntityExpansion.
+	// MaxTokenSize is checked once the decoder has reported the
+	// token.
+	MaxDepth     int
+	MaxAttrs     int
+	MaxTokenSize int64
+	MaxBytes     int64
+	MaxTokens    int64
+
+	// MaxEntityExpansion limits the total bytes of replacement
+	// text of the entities declared by the DOCTYPE, when
+	// ExpandEntities is set: both that of each declaration, after
+	// the expansion of the references it contains, and that
+	// substituted for each reference in the document.  If zero,
+	// the limit is MaxBytes, or 64 MiB if MaxBytes is not set
+	// either.  The limit is enforced as the input is read, before
+	// a reference is expanded.  References are recognized wherever they appear,
+	// including within comments and CDATA sections, where they are
+	// not expanded, so the count may be high.
+	MaxEntityExpansion int64
+
+	// Recovery determines how the transform continues after an
+	// error for which handler.Error returns false.
+	//
+	// RecoverResync restarts the decoder on the input following a
+	// syntax error, and so reads the input one byte at a time.
+	// The encoding named by the XML declaration is converted
+	// before decoding.
+	Recovery Recovery
+
+	// CollectErrors, if true, causes the errors passed to
+	// handler.Error to be returned as an ErrorList, including any
+	// error that aborted the transform.
+	CollectErrors bool
+
+	// MaxErrors, if greater than zero, aborts the transform once
+	// that many errors have been passed to handler.Error.
+	MaxErrors int
+
+	// RawTokens, if true, causes tokens to be read with
+	// xml.Decoder.RawToken rather than xml.Decoder.Token.  Names
+	// are passed to the handler with the prefix as written in
+	// Name.Space rather than translated to a namespace uri, end
+	// tags are not checked against start tags, and AutoClose is
+	// not applied.  Handlers that need namespace uris may track
+	// them with an xmlns.XmlNamespace, using its Translate method.
+	RawTokens bool
+
+	// KeepOriginal, if true, records the bytes of input of each
+	// token, which are reported along with a copy of the token by
+	// the Locator passed to a LocatorSetter.  As with
+	// RecoverResync, the input is read one byte at a time and
+	// converted to UTF-8 before decoding.
+	KeepOriginal bool
+
+	// CDATA, if true, causes CDATA sections to be reported as
+	// CData tokens, which Dispatch passes to handlers implementing
+	// CDataHandler, and to other handlers as xml.CharData.  A stage
+	// embedding Filter sees CDATA sections as character data unless
+	// it implements CDataHandler itself.  As with KeepOriginal, the
+	// input is read one byte at a time and converted to UTF-8
+	// before decoding.
+	CDATA bool
+
+	// ExpandEntities, if true, causes the internal general
+	// entities declared by the DOCTYPE of the document to be added
+	// to the entities recognized by the decoder, taking precedence
+	// over those in Entity.  As with Entity, the replacement text
+	// of an entity is treated as character data.  If
+	// EntityResolver is not nil, it is used to load the external
+	// subset of the DTD, whose declarations follow those of the
+	// internal subset.  See ParseDoctype.  As with KeepOriginal, the
+	// input is read one byte at a time and converted to UTF-8
+	// before decoding.
+	ExpandEntities bool
+	EntityResolver EntityResolver
+
+	// PathFormat controls the rendering of the path of the current
+	// element reported by the Locator and by a TransformError, for
+	// example to qualify elements with their sibling positions.
+	PathFormat xmlpath.Format
+}
+
+// NewTransformOptions returns the default TransformOptions: strict
+// parsing, using the CharsetReader provided by this package.
+func NewTransformOptions() *TransformOptions {
+	return &TransformOptions{
+		CharsetReader: CharsetReader,
+		Strict:        true,
+	}
+}
+
+// OptionsSetter may be implemented by a Handler whose handling of
+// events depends on the TransformOptions in effect, such as whether
+// names are raw.  Transform calls SetOptions before reporting the
+// first event.  The options must not be modified.
+type OptionsSetter interface {
+	SetOptions(*TransformOptions)
+}
+
+// NewHTMLTransformOptions returns TransformOptions suited to
+// HTML-like XML: parsing is not strict, HTML elements that are
+// conventionally left open are closed automatically, and the HTML
+// character entities are recognized.
+func NewHTMLTransformOptions() *TransformOptions {
+	return &TransformOptions{
+		CharsetReader: CharsetReader,
+		Strict:        false,
+		AutoClose:     xml.HTMLAutoClose,
+		Entity:        xml.HTMLEntity,
+	}
+}
+
+// TransformWithOptions is like Transform, but decodes the document
+// according to opts.  If opts is nil the default TransformOptions
+// are used.
+//
+// Input in UTF-16 is detected and converted to UTF-8 before decoding,
+// in which case the encoding declared by the document is not passed
+// to the CharsetReader.
+func TransformWithOptions(r io.Reader, handler Handler, opts *TransformOptions) error {
+	return TransformContext(context.Background(), r, handler, opts)
+}
 
+// TransformContext is like TransformWithOptions, but stops with the
+// error ctx.Err() if ctx is done before the transform completes.  The
+// context is checked before each token is read.
+//
+// Errors from ctx and limits set in opts abort the transform without
+// being passed to handler.Error.
+func TransformContext(ctx context.Context, r io.Reader, handler Handler, opts *TransformOptions) error {
+	if opts == nil {
+		opts = NewTransformOptions()
+	}
+	handler, opts = prepare(handler, opts)
+
+	if opts.MaxBytes > 0 {
+		r = &limitReader{r: r, n: opts.MaxBytes}
+	}
+	t := &transformer{ctx: ctx, handler: handler, opts: opts}
+	r, t.utf8 = NewUTF8Reader(r)
+	if opts.Recovery == RecoverResync || opts.KeepOriginal || opts.CDATA || opts.ExpandEntities {
+		if !t.utf8 && opts.CharsetReader != nil {
+			r, t.utf8 = decodeCharset(r, opts.CharsetReader)
+		}
+		t.input = newInputReader(r)
+		t.input.keep = opts.KeepOriginal || opts.CDATA
+		if opts.ExpandEntities {
+			t.input.exp = &expansion{max: opts.maxEntityExpansion()}
+		}
+		t.base = Position{Line: 1, Column: 1}
+		t.resynced = -1
+		r = t.input
+	}
+	t.dec = t.newDecoder(r)
+	return t.run()
+}
+
+// prepare returns the handler and options with which a transform is
+// run: a Canonicalizer is passed raw tokens, and a Trackable handler
+// is passed through Track.
+func prepare(handler Handler, opts *TransformOptions) (Handler, *TransformOptions) {
+	switch h := handler.(type) {
+	case *Canonicalizer:
+		// the canonical form keeps the prefixes of the document
+		h.Raw = true
+		if !opts.RawTokens {
+			raw := *opts
+			raw.RawTokens = true
+			opts = &raw
+		}
+	case Trackable:
+		handler = Track(h)
+	}
+	return handler, opts
+}
+
+// newDecoder returns a decoder reading r, configured by the options
+func (t *transformer) newDecoder(r io.Reader) *xml.Decoder {
+	opts := t.opts
 	dec := xml.NewDecoder(r)
+	if t.utf8 {
+		dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		}
+	} else {
+		dec.CharsetReader = opts.CharsetReader
+	}
+	dec.Strict = opts.Strict
+	dec.AutoClose = opts.AutoClose
+	dec.Entity = opts.Entity
+	if t.entity != nil {
+		dec.Entity = t.entity
+	}
+	return dec
+}
+
+// TransformDecoder is like Transform, but reads the document from a
+// Decoder configured by the caller.  As with Transform, a handler
+// embedding a *BaseHandler is passed through Track, and a
+// Canonicalizer is passed raw tokens.
+func TransformDecoder(dec *xml.Decoder, handler Handler) error {
+	handler, opts := prepare(handler, &TransformOptions{})
+	t := &transformer{ctx: context.Background(), dec: dec, handler: handler, opts: opts}
+	return t.run()
+}
+
+// transformer holds the state of a single transform
+type transformer struct {
+	ctx     context.Context
+	dec     *xml.Decoder
+	handler Handler
+	opts    *TransformOptions
+
+	utf8   bool              // input has been converted to UTF-8
+	pos    Position          // position of the current token
+	path   *xmlpath.XmlPath  // path of the current element
+	depth  int               // element nesting depth
+	tokens int64             // tokens read
+	offset int64             // input offset at the end of the last token
+	skip   int               // depth within an element being skipped
+	errs   ErrorList         // errors collected
+	entity map[string]string // entities declared by the DOCTYPE
+	nerrs  int               // errors passed to the handler
+
+	// original token and its bytes of input, used with KeepOriginal
+	tok xml.Token
+	raw []byte
+
+	// input state, used with RecoverResync and KeepOriginal
+	input    *inputReader
+	opened   []openElement // elements open in the input
+	base     Position      // position of the start of the decoder's input
+	prefix   int64         // length of the synthetic prefix read by the decoder
+	resynced int64         // input offset of the last resynchronization
+}
+
+// run iterates over the tokens reported by the decoder, calling the
+// handler for each.
+func (t *transformer) run() (err error) {
+	defer func() {
+		if ferr := t.handler.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+
+	t.path = xmlpath.NewXmlPath()
+	if setter, ok := t.handler.(OptionsSetter); ok {
+		setter.SetOptions(t.opts)
+	}
+	if ls, ok := t.handler.(LocatorSetter); ok {
+		ls.SetLocator(t)
+	}
+
+	done := t.ctx.Done()
 	for {
-		var tok xml.Token
-		if tok, err = dec.Token(); err == nil {
-			switch node := tok.(type) {
-			case xml.StartElement:
-				err = handler.StartElement(node)
-			case xml.EndElement:
-				err = handler.EndElement(node)
-			case xml.CharData:
-				err = handler.CharData(node)
-			case xml.Comment:
-				err = handler.Comment(node)
-			case xml.Directive:
-				err = handler.Directive(node)
-			case xml.ProcInst:
-				err = handler.ProcInst(node)
+		if done != nil {
+			select {
+			case <-done:
+				return t.ctx.Err()
 			default:
-				err = fmt.Errorf("unhandled type: %v %v", node, tok)
 			}
 		}
-		if err != nil {
+
+		t.mark()
+		var tok xml.Token
+		if tok, err = t.token(); err != nil {
 			if err == io.EOF {
-				return nil
+				return t.result()
+			}
+			var lerr *LimitError
+			if errors.As(err, &lerr) {
+				return t.wrap(err)
 			}
-			if handler.Error(err) {
+			err = t.wrap(err)
+			resync := t.resyncable(err)
+			if err = t.recover(err, resync); err != nil {
 				return err
 			}
+			var done bool
+			if done, err = t.resync(); done || err != nil {
+				if err == nil {
+					err = t.result()
+				}
+				return err
+			}
+		} else if err = t.limit(tok); err != nil {
+			return t.wrap(err)
+		} else if err = t.doctype(tok); err != nil {
+			return err
+		} else if err = t.dispatch(tok); err == io.EOF {
+			return t.result()
+		} else if err != nil {
+			if err = t.recover(err, true); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// token returns the next token from the decoder
+func (t *transformer) token() (tok xml.Token, err error) {
+	if t.opts.RawTokens {
+		tok, err = t.dec.RawToken()
+	} else {
+		tok, err = t.dec.Token()
+	}
+	if err == nil && t.input != nil && t.input.keep {
+		tok = t.keep(tok)
+	}
+	return
+}
+
+// dispatch passes tok to the handler, maintaining the element path.
+// Tokens within an element being skipped are not passed on.
+func (t *transformer) dispatch(tok xml.Token) (err error) {
+	switch node := tok.(type) {
+	case xml.StartElement:
+		if t.opts.RawTokens {
+			t.path.PushRaw(node)
+		} else {
+			t.path.Push(node)
+		}
+		t.open(node)
+		if t.skip > 0 {
+			t.skip++
+			return
+		}
+	case xml.EndElement:
+		defer t.path.Pop()
+		defer t.close()
+		if t.skip > 0 {
+			t.skip--
+			return
+		}
+	default:
+		if t.skip > 0 {
+			return
+		}
+	}
+
+	if err = Dispatch(t.handler, tok); err != nil && err != io.EOF {
+		err = t.wrap(err)
+		if _, ok := tok.(xml.StartElement); ok && t.opts.Recovery >= RecoverSkip {
+			t.skip = 1
 		}
 	}
+	return
+}
 
+// Dispatch calls the handler method appropriate for the type of tok.
+func Dispatch(handler Handler, tok xml.Token) (err error) {
+	switch node := tok.(type) {
+	case xml.StartElement:
+		err = handler.StartElement(node)
+	case xml.EndElement:
+		err = handler.EndElement(node)
+	case xml.CharData:
+		err = handler.CharData(node)
+	case xml.Comment:
+		err = handler.Comment(node)
+	case xml.Directive:
+		err = handler.Directive(node)
+	case xml.ProcInst:
+		err = handler.ProcInst(node)
+	case CData:
+		if ch, ok := handler.(CDataHandler); ok {
+			err = ch.CData(node)
+		} else {
+			err = handler.CharData(xml.CharData(node))
+		}
+	default:
+		err = fmt.Errorf("unhandled type: %v", tok)
+	}
 	return
 }
 
@@ -78,6 +456,7 @@ type Handler interface {
 
 	// Error will be passed any non-io.EOF errors for evaluation
 	// or reporting.  If true is returned, the Transform will be
-	// aborted and the error returned.
+	// aborted and the error returned.  If false is returned, the
+	// Transform recovers as directed by TransformOptions.Recovery.
 	Error(error) (abort bool)
 }
@@ -0,0 +1,250 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// encodeUTF16 encodes s as UTF-16, with a byte order mark if bom is set
+func encodeUTF16(s string, bigEndian, bom bool) []byte {
+	var b []byte
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+	for _, u := range units {
+		if bigEndian {
+			b = append(b, byte(u>>8), byte(u))
+		} else {
+			b = append(b, byte(u), byte(u>>8))
+		}
+	}
+	return b
+}
+
+type charsetTest struct {
+	descr string
+	input []byte
+}
+
+const charsetOutput = `<a b='é€'>é€ 😀</a>`
+
+var charsetTests = []charsetTest{
+	{"UTF-8", []byte(`<a b="é€">é€ 😀</a>`)},
+	{"UTF-8 with BOM", []byte("\xef\xbb\xbf" + `<?xml version="1.0" encoding="UTF-8"?><a b="é€">é€ 😀</a>`)},
+	{"ISO-8859-15", []byte("<?xml version=\"1.0\" encoding=\"ISO-8859-15\"?><a b=\"\xe9\xa4\">\xe9\xa4 &#x1F600;</a>")},
+	{"windows-1252", []byte("<?xml version=\"1.0\" encoding=\"windows-1252\"?><a b=\"\xe9\x80\">\xe9\x80 &#x1F600;</a>")},
+	{"UTF-16BE with BOM", encodeUTF16(`<?xml version="1.0" encoding="UTF-16"?><a b="é€">é€ 😀</a>`, true, true)},
+	{"UTF-16LE with BOM", encodeUTF16(`<?xml version="1.0" encoding="UTF-16"?><a b="é€">é€ 😀</a>`, false, true)},
+	{"UTF-16LE without BOM", encodeUTF16(`<?xml version="1.0" encoding="UTF-16"?><a b="é€">é€ 😀</a>`, false, false)},
+	{"UTF-16BE large", encodeUTF16(`<a b="é€">`+strings.Repeat("é€ 😀", 2000)+`</a>`, true, true)},
+}
+
+func TestTransformCharset(t *testing.T) {
+	for _, v := range charsetTests {
+		w := new(bytes.Buffer)
+		h := NewIdentityTransform(w)
+		h.Declaration = DeclStrip
+		if err := Transform(bytes.NewReader(v.input), h); err != nil {
+			t.Errorf("%s: %v", v.descr, err)
+			continue
+		}
+		expected := charsetOutput
+		if strings.HasSuffix(v.descr, "large") {
+			expected = `<a b='é€'>` + strings.Repeat("é€ 😀", 2000) + `</a>`
+		}
+		if w.String() != expected {
+			t.Errorf("%s: expected %s, got %s", v.descr, expected, w.String())
+		}
+	}
+
+	err := Transform(strings.NewReader(`<?xml version="1.0" encoding="EBCDIC"?><a/>`), NewIdentityTransform(new(bytes.Buffer)))
+	if err == nil {
+		t.Error("expected an error for an unsupported charset")
+	}
+}
+
+type optionsTest struct {
+	descr  string
+	opts   *TransformOptions
+	input  string
+	output string
+	err    bool
+}
+
+var optionsTests = []optionsTest{
+	{
+		"Strict rejects HTML",
+		NewTransformOptions(),
+		`<p>a&nbsp;b<br></p>`,
+		``,
+		true,
+	},
+	{
+		"HTML options",
+		NewHTMLTransformOptions(),
+		`<p class=x>a&nbsp;b<br></p>`,
+		"<p class='x'>a b<br></br></p>",
+		false,
+	},
+	{
+		"Custom entity",
+		&TransformOptions{Strict: true, Entity: map[string]string{"company": "ACME"}},
+		`<p>&company;</p>`,
+		`<p>ACME</p>`,
+		false,
+	},
+}
+
+func TestTransformWithOptions(t *testing.T) {
+	for _, v := range optionsTests {
+		w := new(bytes.Buffer)
+		err := TransformWithOptions(strings.NewReader(v.input), NewIdentityTransform(w), v.opts)
+		if v.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", v.descr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", v.descr, err)
+			continue
+		}
+		if w.String() != v.output {
+			t.Errorf("%s: expected %s, got %s", v.descr, v.output, w.String())
+		}
+	}
+}
+
+func TestTransformDecoder(t *testing.T) {
+	dec := xml.NewDecoder(strings.NewReader(`<p>&copy;</p>`))
+	dec.Entity = xml.HTMLEntity
+
+	w := new(bytes.Buffer)
+	if err := TransformDecoder(dec, NewIdentityTransform(w)); err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != `<p>©</p>` {
+		t.Errorf("expected <p>©</p>, got %s", w.String())
+	}
+
+	// handlers are set up as they are by Transform
+	w.Reset()
+	b, err := NewBaseHandler(w, "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &baseHandler{BaseHandler: b}
+	dec = xml.NewDecoder(strings.NewReader(`<p xmlns="` + xhtmlSpace + `" xml:base="a/"><a href="x"/></p>`))
+	if err := TransformDecoder(dec, h); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `<p xmlns='` + xhtmlSpace + `' xml:base='a/'><a href='http://example.com/a/x'></a></p>`; w.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+
+	w.Reset()
+	dec = xml.NewDecoder(strings.NewReader(`<p:a xmlns:p="urn:x" xmlns:q="urn:x"><q:b/><p:b/></p:a>`))
+	if err := TransformDecoder(dec, NewCanonicalizer(w)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `<p:a xmlns:p="urn:x" xmlns:q="urn:x"><q:b></q:b><p:b></p:b></p:a>`; w.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+}
+
+type limitTest struct {
+	descr string
+	opts  TransformOptions
+	input string
+	limit Limit
+}
+
+var limitInput = `<a><b x="1" y="2"><c>` + strings.Repeat("text ", 20) + `</c></b></a>`
+
+var limitTests = []limitTest{
+	{"depth", TransformOptions{MaxDepth: 2}, limitInput, LimitDepth},
+	{"attrs", TransformOptions{MaxAttrs: 1}, limitInput, LimitAttrs},
+	{"token size", TransformOptions{MaxTokenSize: 50}, limitInput, LimitTokenSize},
+	{"bytes", TransformOptions{MaxBytes: 64}, limitInput, LimitBytes},
+	{"tokens", TransformOptions{MaxTokens: 4}, limitInput, LimitTokens},
+	{"none", TransformOptions{MaxDepth: 3, MaxAttrs: 2, MaxTokenSize: 100, MaxBytes: int64(len(limitInput)), MaxTokens: 7}, limitInput, -1},
+}
+
+func TestTransformLimits(t *testing.T) {
+	for _, v := range limitTests {
+		opts := v.opts
+		opts.Strict = true
+		err := TransformWithOptions(strings.NewReader(v.input), NewIdentityTransform(new(bytes.Buffer)), &opts)
+		if v.limit < 0 {
+			if err != nil {
+				t.Errorf("%s: %v", v.descr, err)
+			}
+			continue
+		}
+		var lerr *LimitError
+		if !errors.As(err, &lerr) {
+			t.Errorf("%s: expected a *LimitError, got %v", v.descr, err)
+			continue
+		}
+		if lerr.Limit != v.limit {
+			t.Errorf("%s: expected the %s limit, got %s", v.descr, v.limit, lerr.Limit)
+		}
+	}
+}
+
+// cancelHandler cancels a context after n start elements
+type cancelHandler struct {
+	*IdentityTransform
+	n      int
+	cancel context.CancelFunc
+}
+
+func (h *cancelHandler) StartElement(node xml.StartElement) error {
+	if h.n--; h.n == 0 {
+		h.cancel()
+	}
+	return h.IdentityTransform.StartElement(node)
+}
+
+func TestTransformContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := new(bytes.Buffer)
+	h := &cancelHandler{IdentityTransform: NewIdentityTransform(w), n: 2, cancel: cancel}
+	err := TransformContext(ctx, strings.NewReader(limitInput), h, nil)
+	if err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	if w.String() != `<a><b x='1' y='2'>` {
+		t.Errorf("expected output to stop after <b>, got %s", w.String())
+	}
+}
+
+const rawInput = `<a:x xmlns:a="urn:u" xmlns:b="urn:u"><b:y b:z="1" xml:space="preserve">  </b:y><u:q/></a:x>`
+
+func TestRawTokens(t *testing.T) {
+	w := new(bytes.Buffer)
+	h := &positionHandler{IdentityTransform: NewIdentityTransform(w)}
+	h.Raw = true
+	h.Indent = "  "
+	opts := NewTransformOptions()
+	opts.RawTokens = true
+	if err := TransformWithOptions(strings.NewReader(rawInput), h, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "<a:x xmlns:a='urn:u' xmlns:b='urn:u'>\n  <b:y b:z='1' xml:space='preserve'>  </b:y>\n  <u:q></u:q>\n</a:x>"
+	if w.String() != expected {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+
+	paths := "/a:x 1:1@0\n/a:x/b:y 1:38@37\n/a:x/u:q 1:80@79"
+	if strings.Join(h.seen, "\n") != paths {
+		t.Errorf("expected\n%s\ngot\n%s", paths, strings.Join(h.seen, "\n"))
+	}
+}
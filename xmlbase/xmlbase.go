@@ -19,6 +19,16 @@ import (
 type XmlBase struct {
 	baseUri []*IRI
 	depth   []int
+
+	// Resolver, if set, maps the IRIs returned by Resolve, for
+	// example to local copies of remote resources
+	Resolver URIResolver
+}
+
+// URIResolver maps an absolute URI to another, such as the location
+// of a local copy of the resource, reporting whether it was mapped.
+type URIResolver interface {
+	ResolveURI(uri string) (string, bool)
 }
 
 func NewXmlBase(baseUri string) (xb *XmlBase, err error) {
@@ -36,12 +46,16 @@ func NewXmlBase(baseUri string) (xb *XmlBase, err error) {
 const xmlBaseSpace = "http://www.w3.org/XML/1998/namespace"
 const xmlBaseLocal = "base"
 
+// xmlBasePrefix is the name space of xml:base as reported by
+// xml.Decoder.RawToken
+const xmlBasePrefix = "xml"
+
 // Push adds xml:base from xml.StartElement to the stack
 func (xb *XmlBase) Push(node xml.StartElement) (err error) {
 	var rawurl string
 	var exists bool
 	for _, attr := range node.Attr {
-		if attr.Name.Space == xmlBaseSpace && attr.Name.Local == xmlBaseLocal {
+		if (attr.Name.Space == xmlBaseSpace || attr.Name.Space == xmlBasePrefix) && attr.Name.Local == xmlBaseLocal {
 			rawurl = attr.Value
 			exists = true
 			break
@@ -121,7 +135,8 @@ func (xb *XmlBase) Pop() {
 	return
 }
 
-// Resolve returns the resolved version of rawurl based on the current xml:base URL
+// Resolve returns the resolved version of rawurl based on the current
+// xml:base URL, as mapped by the Resolver, if any
 func (xb *XmlBase) Resolve(rawurl string) (iri string, err error) {
 	var u *IRI
 	u, err = NewIRI(rawurl)
@@ -132,7 +147,13 @@ func (xb *XmlBase) Resolve(rawurl string) (iri string, err error) {
 		n := len(xb.baseUri) - 1
 		u = xb.baseUri[n].ResolveReference(u)
 	}
-	return u.String()
+	if iri, err = u.String(); err != nil || xb.Resolver == nil {
+		return
+	}
+	if mapped, ok := xb.Resolver.ResolveURI(iri); ok {
+		iri = mapped
+	}
+	return
 }
 
 // URL returns the current xml:base URL
@@ -137,6 +137,67 @@ func TestXMLBasePush(t *testing.T) {
 	}
 }
 
+func TestXMLBasePushRaw(t *testing.T) {
+	xmlbase, err := NewXmlBase("http://example.org/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := xml.NewDecoder(strings.NewReader(`<a xml:base="one/"><b xml:base="two/"/></a>`))
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if node, ok := tok.(xml.StartElement); ok {
+			xmlbase.Push(node)
+		}
+	}
+
+	iri, err := xmlbase.Resolve("three")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "http://example.org/one/two/three"; iri != expected {
+		t.Errorf("expected %s, got %s", expected, iri)
+	}
+}
+
+// prefixResolver maps URIs beginning with from to URIs beginning with to
+type prefixResolver struct {
+	from, to string
+}
+
+func (r prefixResolver) ResolveURI(uri string) (string, bool) {
+	if !strings.HasPrefix(uri, r.from) {
+		return "", false
+	}
+	return r.to + uri[len(r.from):], true
+}
+
+func TestXMLBaseResolver(t *testing.T) {
+	xmlbase, err := NewXmlBase("http://example.org/schemas/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xmlbase.Resolver = prefixResolver{"http://example.org/", "file:///usr/share/xml/example/"}
+
+	for _, v := range []struct{ rawurl, iri string }{
+		{"a.xsd", "file:///usr/share/xml/example/schemas/a.xsd"},
+		{"http://example.com/b.xsd", "http://example.com/b.xsd"},
+	} {
+		iri, err := xmlbase.Resolve(v.rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if iri != v.iri {
+			t.Errorf("%s: expected %s, got %s", v.rawurl, v.iri, iri)
+		}
+	}
+}
+
 func TestXMLBasePushHTML(t *testing.T) {
 	for i, v := range xmlBaseTests {
 		xmlbase, err := NewXmlBase("")
@@ -0,0 +1,416 @@
+// Package xmlcatalog implements OASIS XML Catalogs, which map the
+// public and system identifiers of external entities, such as DTDs,
+// and other URIs to local resources.
+//
+// The public, system, rewriteSystem, systemSuffix, uri, rewriteURI,
+// uriSuffix, group and nextCatalog entries are supported, along with
+// the prefer and xml:base attributes.  Delegation entries are
+// ignored.
+//
+// A Catalog implements transform.EntityResolver, so that DTDs may be
+// loaded from local copies, and xmlbase.URIResolver.
+//
+// See https://www.oasis-open.org/committees/download.php/14809/xml-catalogs.html
+package xmlcatalog
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/jimrobinson/xml/xmlbase"
+)
+
+// Namespace is the namespace of the elements of a catalog
+const Namespace = "urn:oasis:names:tc:entity:xmlns:xml:catalog"
+
+// ErrNotFound is returned by ResolveEntity for an identifier that is
+// not mapped by the catalog
+var ErrNotFound = errors.New("xmlcatalog: not found")
+
+// Catalog is a parsed catalog file, along with the catalogs named by
+// its nextCatalog entries, which are loaded when first needed.  A
+// Catalog may be used concurrently.
+type Catalog struct {
+	entries []entry
+	next    []string // URIs of the next catalogs
+	cache   *cache
+}
+
+type kind int
+
+const (
+	publicEntry kind = iota
+	systemEntry
+	rewriteSystemEntry
+	systemSuffixEntry
+	uriEntry
+	rewriteURIEntry
+	uriSuffixEntry
+)
+
+// entry is a single mapping of a catalog
+type entry struct {
+	kind         kind
+	match        string // identifier, prefix or suffix matched
+	target       string // absolute URI, or prefix of the rewritten URI
+	preferPublic bool   // public entries apply when a system identifier is given
+}
+
+// cache holds the catalogs loaded through nextCatalog entries, by URI,
+// so that each is loaded once and cycles terminate
+type cache struct {
+	mu       sync.Mutex
+	catalogs map[string]*Catalog
+}
+
+// Load parses the catalog file at path, which may be a file path or
+// a file: URI.
+func Load(path string) (*Catalog, error) {
+	return load(fileURI(path), &cache{catalogs: make(map[string]*Catalog)})
+}
+
+func load(uri string, c *cache) (*Catalog, error) {
+	rc, err := Open(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return parse(rc, uri, c)
+}
+
+// Parse reads a catalog from r.  Relative URIs in the catalog are
+// resolved against base, the URI of the catalog.
+func Parse(r io.Reader, base string) (*Catalog, error) {
+	return parse(r, base, &cache{catalogs: make(map[string]*Catalog)})
+}
+
+func parse(r io.Reader, base string, c *cache) (*Catalog, error) {
+	xb, err := xmlbase.NewXmlBase(base)
+	if err != nil {
+		return nil, err
+	}
+	cat := &Catalog{cache: c}
+
+	prefer := []bool{true}
+	skip := 0 // depth within elements of other namespaces
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("xmlcatalog: %s: %v", base, err)
+		}
+
+		switch node := tok.(type) {
+		case xml.StartElement:
+			if skip > 0 || node.Name.Space != Namespace {
+				skip++
+				continue
+			}
+			if err = xb.Push(node); err != nil {
+				return nil, fmt.Errorf("xmlcatalog: %s: %v", base, err)
+			}
+			p := prefer[len(prefer)-1]
+			if v, ok := attr(node, "prefer"); ok {
+				p = v == "public"
+			}
+			prefer = append(prefer, p)
+			if err = cat.add(node, xb, p); err != nil {
+				return nil, fmt.Errorf("xmlcatalog: %s: %v", base, err)
+			}
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			xb.Pop()
+			prefer = prefer[:len(prefer)-1]
+		}
+	}
+	return cat, nil
+}
+
+// add records the entry represented by node
+func (cat *Catalog) add(node xml.StartElement, xb *xmlbase.XmlBase, preferPublic bool) (err error) {
+	var k kind
+	var matchAttr, targetAttr string
+	switch node.Name.Local {
+	case "public":
+		k, matchAttr, targetAttr = publicEntry, "publicId", "uri"
+	case "system":
+		k, matchAttr, targetAttr = systemEntry, "systemId", "uri"
+	case "rewriteSystem":
+		k, matchAttr, targetAttr = rewriteSystemEntry, "systemIdStartString", "rewritePrefix"
+	case "systemSuffix":
+		k, matchAttr, targetAttr = systemSuffixEntry, "systemIdSuffix", "uri"
+	case "uri":
+		k, matchAttr, targetAttr = uriEntry, "name", "uri"
+	case "rewriteURI":
+		k, matchAttr, targetAttr = rewriteURIEntry, "uriStartString", "rewritePrefix"
+	case "uriSuffix":
+		k, matchAttr, targetAttr = uriSuffixEntry, "uriSuffix", "uri"
+	case "nextCatalog":
+		if v, ok := attr(node, "catalog"); ok {
+			var next string
+			if next, err = xb.Resolve(v); err != nil {
+				return
+			}
+			cat.next = append(cat.next, next)
+		}
+		return
+	default:
+		return
+	}
+
+	e := entry{kind: k, preferPublic: preferPublic}
+	match, ok1 := attr(node, matchAttr)
+	target, ok2 := attr(node, targetAttr)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("%s entry requires %s and %s", node.Name.Local, matchAttr, targetAttr)
+	}
+	if k == publicEntry {
+		match = NormalizePublicID(match)
+	}
+	e.match = match
+	if e.target, err = xb.Resolve(target); err != nil {
+		return
+	}
+	cat.entries = append(cat.entries, e)
+	return
+}
+
+// attr returns the value of the unqualified attribute named local
+func attr(node xml.StartElement, local string) (string, bool) {
+	for _, a := range node.Attr {
+		if a.Name.Space == "" && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// ResolveExternal returns the URI of the resource identified by the
+// public and system identifiers of an external identifier, either
+// of which may be empty.  System entries are consulted first, then
+// public entries, then the next catalogs.
+func (cat *Catalog) ResolveExternal(publicID, systemID string) (string, bool) {
+	publicID = NormalizePublicID(publicID)
+	if strings.HasPrefix(systemID, urnPublicID) {
+		if publicID == "" {
+			publicID = unwrapURN(systemID)
+		}
+		systemID = ""
+	}
+	return cat.resolve(make(map[*Catalog]bool), func(c *Catalog) (string, bool) {
+		return c.external(publicID, systemID)
+	})
+}
+
+// ResolveURI returns the URI mapped to uri by the catalog's uri,
+// rewriteURI and uriSuffix entries.  It implements
+// xmlbase.URIResolver.
+func (cat *Catalog) ResolveURI(uri string) (string, bool) {
+	if strings.HasPrefix(uri, urnPublicID) {
+		return cat.ResolveExternal(unwrapURN(uri), "")
+	}
+	return cat.resolve(make(map[*Catalog]bool), func(c *Catalog) (string, bool) {
+		return c.match(uriEntry, rewriteURIEntry, uriSuffixEntry, uri)
+	})
+}
+
+// ResolveEntity opens the resource identified by an external
+// identifier, if it is mapped by the catalog to a file.  An
+// identifier that is not mapped results in an error wrapping
+// ErrNotFound: no attempt is made to fetch the system identifier.
+// It implements transform.EntityResolver.
+func (cat *Catalog) ResolveEntity(publicID, systemID string) (io.ReadCloser, error) {
+	uri, ok := cat.ResolveExternal(publicID, systemID)
+	if !ok {
+		return nil, fmt.Errorf("%w: public %q, system %q", ErrNotFound, publicID, systemID)
+	}
+	return Open(uri)
+}
+
+// resolve applies f to cat, and then to each next catalog in turn,
+// depth first, until one resolves the identifier
+func (cat *Catalog) resolve(seen map[*Catalog]bool, f func(*Catalog) (string, bool)) (string, bool) {
+	if seen[cat] {
+		return "", false
+	}
+	seen[cat] = true
+	if s, ok := f(cat); ok {
+		return s, true
+	}
+	for _, next := range cat.next {
+		if c := cat.cache.get(next); c != nil {
+			if s, ok := c.resolve(seen, f); ok {
+				return s, true
+			}
+		}
+	}
+	return "", false
+}
+
+// get returns the catalog at uri, loading it if need be.  A catalog
+// that cannot be loaded is ignored.
+func (c *cache) get(uri string) *Catalog {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cat, ok := c.catalogs[uri]
+	if !ok {
+		cat, _ = load(uri, c)
+		c.catalogs[uri] = cat
+	}
+	return cat
+}
+
+// external resolves an external identifier using the entries of cat
+func (cat *Catalog) external(publicID, systemID string) (string, bool) {
+	if systemID != "" {
+		if s, ok := cat.match(systemEntry, rewriteSystemEntry, systemSuffixEntry, systemID); ok {
+			return s, true
+		}
+	}
+	if publicID != "" {
+		for _, e := range cat.entries {
+			if e.kind == publicEntry && e.match == publicID && (systemID == "" || e.preferPublic) {
+				return e.target, true
+			}
+		}
+	}
+	return "", false
+}
+
+// match looks for an entry of kind exact matching s, then for the
+// rewrite entry with the longest matching prefix, then for the
+// suffix entry with the longest matching suffix
+func (cat *Catalog) match(exact, rewrite, suffix kind, s string) (string, bool) {
+	for _, e := range cat.entries {
+		if e.kind == exact && e.match == s {
+			return e.target, true
+		}
+	}
+
+	var best *entry
+	for i, e := range cat.entries {
+		if e.kind == rewrite && strings.HasPrefix(s, e.match) && (best == nil || len(e.match) > len(best.match)) {
+			best = &cat.entries[i]
+		}
+	}
+	if best != nil {
+		return best.target + s[len(best.match):], true
+	}
+
+	for i, e := range cat.entries {
+		if e.kind == suffix && strings.HasSuffix(s, e.match) && (best == nil || len(e.match) > len(best.match)) {
+			best = &cat.entries[i]
+		}
+	}
+	if best != nil {
+		return best.target, true
+	}
+	return "", false
+}
+
+// NormalizePublicID normalizes the whitespace of a public identifier,
+// and unwraps a public identifier written as a urn:publicid: URN.
+func NormalizePublicID(publicID string) string {
+	if strings.HasPrefix(publicID, urnPublicID) {
+		return unwrapURN(publicID)
+	}
+	return strings.Join(strings.Fields(publicID), " ")
+}
+
+const urnPublicID = "urn:publicid:"
+
+var urnReplacer = strings.NewReplacer(
+	"+", " ",
+	":", "//",
+	";", "::",
+	"%2B", "+",
+	"%3A", ":",
+	"%2F", "/",
+	"%3B", ";",
+	"%27", "'",
+	"%3F", "?",
+	"%23", "#",
+	"%25", "%",
+)
+
+// unwrapURN returns the public identifier represented by a
+// urn:publicid: URN
+func unwrapURN(urn string) string {
+	return urnReplacer.Replace(urn[len(urnPublicID):])
+}
+
+// Open opens the resource at uri, which must be a file: URI or a
+// file path.  Other schemes are not supported, so that resolution
+// never reaches the network.
+func Open(uri string) (io.ReadCloser, error) {
+	path, err := filePath(uri)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// windows reports whether file paths may start with a drive letter
+var windows = runtime.GOOS == "windows"
+
+// filePath returns the file path named by uri.  On Windows a path
+// starting with a drive letter, which would otherwise parse as a
+// URI with a single-letter scheme, is returned as is, and the slash
+// before the drive letter of a file: URI is dropped.
+func filePath(uri string) (string, error) {
+	if windows && hasDrive(uri) {
+		return uri, nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "":
+		return uri, nil
+	case "file":
+		path := u.Path
+		if windows && strings.HasPrefix(path, "/") && hasDrive(path[1:]) {
+			path = path[1:]
+		}
+		return filepath.FromSlash(path), nil
+	}
+	return "", fmt.Errorf("xmlcatalog: cannot open %s: only file URIs are supported", uri)
+}
+
+// hasDrive reports whether path starts with a drive letter, as in C:
+func hasDrive(path string) bool {
+	if len(path) < 2 || path[1] != ':' {
+		return false
+	}
+	c := path[0] | 0x20
+	return 'a' <= c && c <= 'z'
+}
+
+// fileURI returns path as a file: URI
+func fileURI(path string) string {
+	if strings.HasPrefix(path, "file:") {
+		return path
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
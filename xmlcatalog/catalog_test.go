@@ -0,0 +1,193 @@
+package xmlcatalog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jimrobinson/xml/transform"
+	"github.com/jimrobinson/xml/xmlbase"
+)
+
+const testCatalog = `<?xml version="1.0"?>
+<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog" prefer="public">
+	<public publicId="-//W3C//DTD XHTML 1.0 Strict//EN" uri="xhtml1-strict.dtd"/>
+	<system systemId="http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd" uri="dtd/xhtml1-strict.dtd"/>
+	<rewriteSystem systemIdStartString="http://example.org/dtd/" rewritePrefix="dtd/example/"/>
+	<rewriteSystem systemIdStartString="http://example.org/dtd/v2/" rewritePrefix="dtd/example2/"/>
+	<systemSuffix systemIdSuffix="/docbook.dtd" uri="dtd/docbook.dtd"/>
+	<group prefer="system" xml:base="http://mirror.example.com/">
+		<public publicId="-//Example//DTD   Sample//EN" uri="sample.dtd"/>
+	</group>
+	<uri name="http://example.org/schema.xsd" uri="xsd/schema.xsd"/>
+	<rewriteURI uriStartString="http://example.org/xsd/" rewritePrefix="xsd/"/>
+	<other:entry xmlns:other="urn:other" uri="ignored"/>
+</catalog>`
+
+func TestResolveExternal(t *testing.T) {
+	cat, err := Parse(strings.NewReader(testCatalog), "file:///etc/xml/catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		publicID, systemID string
+		uri                string
+	}{
+		{"-//W3C//DTD XHTML 1.0 Strict//EN", "", "file:///etc/xml/xhtml1-strict.dtd"},
+		{"-//W3C//DTD XHTML 1.0 Strict//EN", "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd", "file:///etc/xml/dtd/xhtml1-strict.dtd"},
+		{"-//W3C//DTD XHTML 1.0 Strict//EN", "unknown.dtd", "file:///etc/xml/xhtml1-strict.dtd"},
+		{"urn:publicid:-:W3C:DTD+XHTML+1.0+Strict:EN", "", "file:///etc/xml/xhtml1-strict.dtd"},
+		{"", "urn:publicid:-:W3C:DTD+XHTML+1.0+Strict:EN", "file:///etc/xml/xhtml1-strict.dtd"},
+		{"", "http://example.org/dtd/a/b.dtd", "file:///etc/xml/dtd/example/a/b.dtd"},
+		{"", "http://example.org/dtd/v2/c.dtd", "file:///etc/xml/dtd/example2/c.dtd"},
+		{"", "http://docbook.org/xml/4.5/docbook.dtd", "file:///etc/xml/dtd/docbook.dtd"},
+		{"-//Example//DTD Sample//EN", "", "http://mirror.example.com/sample.dtd"},
+		{"-//Example//DTD Sample//EN", "sample.dtd", ""},
+		{"", "http://example.com/unknown.dtd", ""},
+	}
+	for _, v := range tests {
+		uri, ok := cat.ResolveExternal(v.publicID, v.systemID)
+		if ok != (v.uri != "") || uri != v.uri {
+			t.Errorf("%q %q: expected %q, got %q (%v)", v.publicID, v.systemID, v.uri, uri, ok)
+		}
+	}
+}
+
+func TestResolveURI(t *testing.T) {
+	cat, err := Parse(strings.NewReader(testCatalog), "file:///etc/xml/catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, uri string
+	}{
+		{"http://example.org/schema.xsd", "file:///etc/xml/xsd/schema.xsd"},
+		{"http://example.org/xsd/types.xsd", "file:///etc/xml/xsd/types.xsd"},
+		{"http://example.org/other.xsd", ""},
+	}
+	for _, v := range tests {
+		uri, ok := cat.ResolveURI(v.name)
+		if ok != (v.uri != "") || uri != v.uri {
+			t.Errorf("%s: expected %q, got %q (%v)", v.name, v.uri, uri, ok)
+		}
+	}
+
+	xb, err := xmlbase.NewXmlBase("http://example.org/xsd/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xb.Resolver = cat
+	if iri, err := xb.Resolve("types.xsd"); err != nil || iri != "file:///etc/xml/xsd/types.xsd" {
+		t.Errorf("xml:base resolution: got %q, %v", iri, err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNextCatalog(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "catalog.xml"), `<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog">
+	<nextCatalog catalog="sub/catalog.xml"/>
+	<nextCatalog catalog="missing.xml"/>
+</catalog>`)
+	writeFile(t, filepath.Join(dir, "sub", "catalog.xml"), `<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog">
+	<system systemId="http://example.org/a.dtd" uri="a.dtd"/>
+	<nextCatalog catalog="../catalog.xml"/>
+</catalog>`)
+	writeFile(t, filepath.Join(dir, "sub", "a.dtd"), `<!ENTITY a "A">`)
+
+	cat, err := Load(filepath.Join(dir, "catalog.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := cat.ResolveEntity("", "http://example.org/a.dtd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(b) != `<!ENTITY a "A">` {
+		t.Errorf("unexpected content %q, %v", b, err)
+	}
+
+	if _, err = cat.ResolveEntity("", "http://example.org/b.dtd"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := Open("http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd"); err == nil {
+		t.Errorf("expected an error opening an http URI")
+	}
+}
+
+func TestFilePath(t *testing.T) {
+	defer func(w bool) { windows = w }(windows)
+
+	tests := []struct {
+		windows bool
+		uri     string
+		path    string
+	}{
+		{false, "/etc/xml/catalog", "/etc/xml/catalog"},
+		{false, "file:///etc/xml/catalog", "/etc/xml/catalog"},
+		{true, `C:\xml\catalog.xml`, `C:\xml\catalog.xml`},
+		{true, "c:/xml/catalog.xml", "c:/xml/catalog.xml"},
+		{true, "file:///C:/xml/catalog.xml", filepath.FromSlash("C:/xml/catalog.xml")},
+		{true, "file:///xml/catalog.xml", filepath.FromSlash("/xml/catalog.xml")},
+	}
+	for _, v := range tests {
+		windows = v.windows
+		path, err := filePath(v.uri)
+		if err != nil {
+			t.Errorf("%s: %v", v.uri, err)
+		} else if path != v.path {
+			t.Errorf("%s: expected %s, got %s", v.uri, v.path, path)
+		}
+	}
+
+	windows = false
+	if _, err := filePath(`C:\xml\catalog.xml`); err == nil {
+		t.Errorf("expected an error for a single-letter scheme outside of Windows")
+	}
+}
+
+func TestTransformWithCatalog(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "catalog.xml"), `<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog">
+	<public publicId="-//W3C//DTD XHTML 1.0 Strict//EN" uri="xhtml.ent"/>
+</catalog>`)
+	writeFile(t, filepath.Join(dir, "xhtml.ent"), `<!ENTITY nbsp "&#160;">`)
+
+	cat, err := Load(filepath.Join(dir, "catalog.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd"><p>a&nbsp;b</p>`
+	opts := transform.NewTransformOptions()
+	opts.ExpandEntities = true
+	opts.EntityResolver = cat
+
+	w := new(bytes.Buffer)
+	if err = transform.TransformWithOptions(strings.NewReader(input), transform.NewIdentityTransform(w), opts); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<p>a\u00a0b</p>"; !strings.HasSuffix(w.String(), expected) {
+		t.Errorf("expected %s, got %s", expected, w.String())
+	}
+}
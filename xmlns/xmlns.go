@@ -54,6 +54,13 @@ func (ns *XmlNamespace) Push(node xml.StartElement) {
 
 // Push adds namespace mappings onto the mapping
 func (ns *XmlNamespace) PushNS(node xml.StartElement, override []xml.Name) {
+	if override == nil && !hasXmlns(node) && len(ns.Stack) > 0 {
+		// no declarations, avoid allocating an empty mapping
+		ns.Stack[len(ns.Stack)-1].depth++
+		ns.Scope[len(ns.Scope)-1].depth++
+		return
+	}
+
 	mapping := &Mapping{
 		Prefix: make(Prefix),
 		Uri:    make(Uri),
@@ -88,6 +95,16 @@ func (ns *XmlNamespace) PushNS(node xml.StartElement, override []xml.Name) {
 	ns.push(mapping)
 }
 
+// hasXmlns reports whether node carries any namespace declarations
+func hasXmlns(node xml.StartElement) bool {
+	for _, attr := range node.Attr {
+		if attr.Name.Space == xmlnsPrefix || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			return true
+		}
+	}
+	return false
+}
+
 // PushHTML adds namespace mappings onto the mapping
 func (ns *XmlNamespace) PushHTML(tok html.Token) {
 	mapping := &Mapping{
@@ -223,3 +240,37 @@ func (ns *XmlNamespace) Prefix(uri string) string {
 	}
 	return ""
 }
+
+// URI returns the namespace uri mapped to prefix, with the empty
+// prefix denoting the default namespace.  The xml prefix is always
+// mapped.  If prefix is not mapped, ok is false.
+func (ns *XmlNamespace) URI(prefix string) (uri string, ok bool) {
+	if prefix == xmlPrefix {
+		return xmlnsSpace, true
+	}
+	m := ns.InScope()
+	if m == nil {
+		return "", false
+	}
+	uri, ok = m.Prefix[prefix]
+	return
+}
+
+// Translate maps the prefix of a name reported by xml.Decoder.RawToken
+// to its namespace uri, following the rules of xml.Decoder.Token:
+// unprefixed element names are in the default namespace, unprefixed
+// attribute names are in no namespace, xmlns attributes are left as
+// is, and a prefix that is not mapped is kept.  Push needs to be
+// called with the element before its names are translated.
+func (ns *XmlNamespace) Translate(name xml.Name, isElementName bool) xml.Name {
+	switch {
+	case name.Space == xmlnsPrefix:
+		return name
+	case name.Space == "" && !isElementName:
+		return name
+	}
+	if uri, ok := ns.URI(name.Space); ok {
+		name.Space = uri
+	}
+	return name
+}
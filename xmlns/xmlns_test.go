@@ -484,3 +484,42 @@ var feedXml = `<?xml version="1.0"?>
       type="application/rdf+xml"/>
   </atom:entry>
 </atom:feed>`
+
+func TestTranslate(t *testing.T) {
+	sample := `<a xmlns="z" xmlns:b="ns-b" b:c="1" d="2" xml:lang="en">` +
+		`<b:e xmlns="" f="3"><g/><u:h/></b:e>` +
+		`<b:i xmlns:b="ns-c"/>` +
+		`</a>`
+
+	cooked := xml.NewDecoder(strings.NewReader(sample))
+	raw := xml.NewDecoder(strings.NewReader(sample))
+	xmlns := NewXmlNamespace()
+	for {
+		expected, err := cooked.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		tok, err := raw.RawToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch node := tok.(type) {
+		case xml.StartElement:
+			xmlns.Push(node)
+			node.Name = xmlns.Translate(node.Name, true)
+			for i := range node.Attr {
+				node.Attr[i].Name = xmlns.Translate(node.Attr[i].Name, false)
+			}
+			tok = node
+		case xml.EndElement:
+			node.Name = xmlns.Translate(node.Name, true)
+			xmlns.Pop()
+			tok = node
+		}
+		if fmt.Sprint(tok) != fmt.Sprint(expected) {
+			t.Errorf("expected %v, got %v", expected, tok)
+		}
+	}
+}
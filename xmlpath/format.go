@@ -0,0 +1,218 @@
+package xmlpath
+
+import (
+	"encoding/xml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NameStyle selects the rendering of names by XmlPath.Format
+type NameStyle int
+
+const (
+	// Prefixed renders prefix:local names.  Without
+	// Format.Prefixes, an element is rendered with the prefix in
+	// scope when it was pushed, or as written when pushed by
+	// PushRaw.  A name whose namespace has no prefix, and is not
+	// the default namespace, is rendered as Q{uri}local.
+	Prefixed NameStyle = iota
+
+	// Clark renders names in Clark notation, {uri}local, and names
+	// in no namespace as local.
+	Clark
+
+	// EQName renders names as EXPath/XPath 3.0 URIQualifiedNames,
+	// Q{uri}local, including Q{}local for names in no namespace.
+	EQName
+)
+
+// Format controls the rendering of a path by XmlPath.Format.  The
+// zero value renders the same path as XmlPath.String.
+type Format struct {
+	// Names selects the rendering of element and attribute names.
+	Names NameStyle
+
+	// Prefixes, if not nil, maps prefixes to the namespace uris
+	// rendered with them in the Prefixed style, in place of the
+	// prefixes of the document.  The empty prefix maps the
+	// namespace of unprefixed element names.  If more than one
+	// prefix maps a namespace, the first in sort order is used.
+	Prefixes map[string]string
+
+	// Positions, if true, qualifies each element with its position
+	// among its siblings of the same name, as in
+	// /feed[1]/entry[3]/title[1].
+	Positions bool
+
+	// Attrs names attributes that identify an element, such as id.
+	// An element carrying one of them is qualified with the first
+	// found, as in /feed/entry[@id='x'], in place of its position.
+	// The namespace of each name is given as a uri.
+	Attrs []xml.Name
+}
+
+// Format renders the path as directed by f
+func (xp *XmlPath) Format(f Format) string {
+	if len(xp.levels) == 0 {
+		return "/"
+	}
+	r := renderer{xp: xp, f: f}
+	if f.Names == Prefixed && f.Prefixes != nil {
+		r.prefixes = invert(f.Prefixes)
+	}
+
+	var b strings.Builder
+	for _, l := range xp.levels {
+		b.WriteByte('/')
+		b.WriteString(r.elementName(l))
+		r.qualify(&b, l)
+	}
+	return b.String()
+}
+
+// qname returns the prefixed name of an element, given the
+// namespaces now in scope
+func (xp *XmlPath) qname(name xml.Name) string {
+	switch name.Space {
+	case "":
+		return name.Local
+	case xmlSpace:
+		return xmlPrefix + ":" + name.Local
+	}
+	if prefix := xp.ns.Prefix(name.Space); prefix != "" {
+		return prefix + ":" + name.Local
+	}
+	if uri, ok := xp.ns.URI(""); ok && uri == name.Space {
+		return name.Local
+	}
+	return eqName(name)
+}
+
+// renderer renders the names of a path
+type renderer struct {
+	xp       *XmlPath
+	f        Format
+	prefixes map[string]string // uri to prefix, from Format.Prefixes
+}
+
+func (r *renderer) elementName(l level) string {
+	switch {
+	case r.f.Names == Clark:
+		return clarkName(l.name)
+	case r.f.Names == EQName:
+		return eqName(l.name)
+	case r.prefixes != nil:
+		return r.prefixed(l.name, true)
+	}
+	return l.qname
+}
+
+// attrName renders the name of an attribute of l
+func (r *renderer) attrName(l level, name xml.Name) string {
+	switch {
+	case r.f.Names == Clark:
+		return clarkName(name)
+	case r.f.Names == EQName:
+		return eqName(name)
+	case r.prefixes != nil:
+		return r.prefixed(name, false)
+	}
+	switch name.Space {
+	case "":
+		return name.Local
+	case xmlSpace:
+		return xmlPrefix + ":" + name.Local
+	}
+	if l.scope != nil {
+		for _, prefix := range l.scope.Uri[name.Space] {
+			if prefix != "" && l.scope.Prefix[prefix] == name.Space {
+				return prefix + ":" + name.Local
+			}
+		}
+	}
+	return eqName(name)
+}
+
+// prefixed renders name with the prefixes of Format.Prefixes
+func (r *renderer) prefixed(name xml.Name, isElementName bool) string {
+	if name.Space == xmlSpace {
+		return xmlPrefix + ":" + name.Local
+	}
+	if isElementName {
+		if space, ok := r.f.Prefixes[""]; ok && name.Space == space {
+			return name.Local
+		} else if ok && name.Space == "" {
+			return eqName(name)
+		}
+	}
+	if name.Space == "" {
+		return name.Local
+	}
+	if prefix, ok := r.prefixes[name.Space]; ok {
+		return prefix + ":" + name.Local
+	}
+	return eqName(name)
+}
+
+// qualify writes the predicate identifying l, if any
+func (r *renderer) qualify(b *strings.Builder, l level) {
+	for _, name := range r.f.Attrs {
+		if value, ok := lookup(l.attr, name); ok {
+			b.WriteString("[@")
+			b.WriteString(r.attrName(l, name))
+			b.WriteByte('=')
+			b.WriteString(quote(value))
+			b.WriteByte(']')
+			return
+		}
+	}
+	if r.f.Positions {
+		b.WriteByte('[')
+		b.WriteString(strconv.Itoa(l.position))
+		b.WriteByte(']')
+	}
+}
+
+// invert maps the namespace uris of prefixes to the first prefix, in
+// sort order, mapping them.  The empty prefix is left out.
+func invert(prefixes map[string]string) map[string]string {
+	keys := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		if prefix != "" {
+			keys = append(keys, prefix)
+		}
+	}
+	sort.Strings(keys)
+	uris := make(map[string]string, len(prefixes))
+	for _, prefix := range keys {
+		if _, ok := uris[prefixes[prefix]]; !ok {
+			uris[prefixes[prefix]] = prefix
+		}
+	}
+	return uris
+}
+
+func clarkName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return "{" + name.Space + "}" + name.Local
+}
+
+func eqName(name xml.Name) string {
+	return "Q{" + name.Space + "}" + name.Local
+}
+
+// quote returns value as an XPath 2.0 string literal, preferring
+// single quotes.  A value containing both quote characters is single
+// quoted, with its single quotes doubled.
+func quote(value string) string {
+	switch {
+	case !strings.Contains(value, "'"):
+		return "'" + value + "'"
+	case !strings.Contains(value, `"`):
+		return `"` + value + `"`
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,400 @@
+package xmlpath
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const xmlPrefix = "xml"
+const xmlSpace = "http://www.w3.org/XML/1998/namespace"
+
+// maxSteps is the number of location steps a pattern may have, one
+// less than the number of bits in the state of a Match
+const maxSteps = 63
+
+// Pattern is a compiled path pattern, a subset of XPath suited to
+// evaluation while a document is streamed.  A pattern is a sequence
+// of location steps, separated by / (child) or // (descendant):
+//
+//	/feed/entry/content   content children of entry children of the root feed
+//	//xhtml:img           img elements anywhere in the xhtml namespace
+//	entry/link            link children of entry elements, at any depth
+//	atom:*                any element in the atom namespace
+//	*:title               title elements in any namespace
+//	{urn:x}a              a elements in the urn:x namespace
+//	entry[@id]            entry elements with an id attribute
+//	link[@rel='alternate'] link elements whose rel attribute is alternate
+//	entry[3]              the third entry child of its parent
+//
+// A pattern that does not begin with / may match at any depth, as
+// though it began with //.  A position predicate counts the preceding
+// siblings that pass the name test and any earlier predicates of the
+// step.
+type Pattern struct {
+	expr      string
+	steps     []step
+	positions int // number of position predicates
+}
+
+// step is a single location step of a pattern
+type step struct {
+	descendant bool // preceded by //
+	name       nameTest
+	preds      []predicate
+}
+
+// nameTest matches the name of an element or attribute.  An empty
+// local name or an any namespace is a wildcard.
+type nameTest struct {
+	space    string
+	local    string
+	anySpace bool
+}
+
+func (n nameTest) match(name xml.Name) bool {
+	return (n.anySpace || n.space == name.Space) && (n.local == "" || n.local == name.Local)
+}
+
+// predicate is an attribute predicate, or a position predicate if
+// position is non-zero
+type predicate struct {
+	position int
+	counter  int // index of the sibling counter of a position predicate
+	attr     nameTest
+	value    string
+	hasValue bool
+}
+
+// Compile parses a pattern.  The prefixes used in name tests are
+// mapped to namespace uris by namespaces; the xml prefix is always
+// mapped.  If namespaces maps the empty prefix, unprefixed element
+// names are in that namespace, otherwise they are in no namespace.
+// Unprefixed attribute names are always in no namespace.
+func Compile(expr string, namespaces map[string]string) (*Pattern, error) {
+	c := &compiler{expr: expr, ns: namespaces}
+	p, err := c.compile()
+	if err != nil {
+		return nil, fmt.Errorf("xmlpath: invalid pattern %q: %v", expr, err)
+	}
+	return p, nil
+}
+
+// MustCompile is like Compile, but panics if the pattern cannot be
+// parsed.
+func MustCompile(expr string, namespaces map[string]string) *Pattern {
+	p, err := Compile(expr, namespaces)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p *Pattern) String() string {
+	return p.expr
+}
+
+// compiler parses the text of a pattern
+type compiler struct {
+	expr string
+	i    int
+	ns   map[string]string
+	p    *Pattern
+}
+
+func (c *compiler) compile() (*Pattern, error) {
+	c.p = &Pattern{expr: c.expr}
+	descendant := true
+	switch {
+	case c.consume("//"):
+	case c.consume("/"):
+		descendant = false
+	}
+	for {
+		s, err := c.step(descendant)
+		if err != nil {
+			return nil, err
+		}
+		c.p.steps = append(c.p.steps, s)
+		if len(c.p.steps) > maxSteps {
+			return nil, fmt.Errorf("more than %d steps", maxSteps)
+		}
+
+		if c.i == len(c.expr) {
+			return c.p, nil
+		}
+		switch {
+		case c.consume("//"):
+			descendant = true
+		case c.consume("/"):
+			descendant = false
+		default:
+			return nil, c.errorf("unexpected %q", c.expr[c.i:])
+		}
+	}
+}
+
+func (c *compiler) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", c.i, fmt.Sprintf(format, args...))
+}
+
+// consume skips prefix, if it is next
+func (c *compiler) consume(prefix string) bool {
+	if strings.HasPrefix(c.expr[c.i:], prefix) {
+		c.i += len(prefix)
+		return true
+	}
+	return false
+}
+
+func (c *compiler) step(descendant bool) (s step, err error) {
+	s.descendant = descendant
+	if s.name, err = c.nameTest(true); err != nil {
+		return
+	}
+	for c.consume("[") {
+		var pred predicate
+		if pred, err = c.predicate(); err != nil {
+			return
+		}
+		s.preds = append(s.preds, pred)
+		if !c.consume("]") {
+			err = c.errorf("expected ]")
+			return
+		}
+	}
+	return
+}
+
+func (c *compiler) predicate() (pred predicate, err error) {
+	if !c.consume("@") {
+		start := c.i
+		for c.i < len(c.expr) && '0' <= c.expr[c.i] && c.expr[c.i] <= '9' {
+			c.i++
+		}
+		if pred.position, err = strconv.Atoi(c.expr[start:c.i]); err != nil || pred.position < 1 {
+			c.i = start
+			return pred, c.errorf("expected attribute or position")
+		}
+		pred.counter = c.p.positions
+		c.p.positions++
+		return
+	}
+
+	if pred.attr, err = c.nameTest(false); err != nil {
+		return
+	}
+	if !c.consume("=") {
+		return
+	}
+	if c.i == len(c.expr) || (c.expr[c.i] != '"' && c.expr[c.i] != '\'') {
+		return pred, c.errorf("expected quoted value")
+	}
+	if pred.value, err = c.literal(); err != nil {
+		return
+	}
+	pred.hasValue = true
+	return
+}
+
+// literal parses a string literal, in which the quote character is
+// escaped by doubling it, as in XPath 2.0
+func (c *compiler) literal() (string, error) {
+	quote := string(c.expr[c.i])
+	c.i++
+	var b strings.Builder
+	for {
+		end := strings.Index(c.expr[c.i:], quote)
+		if end < 0 {
+			return "", c.errorf("unterminated value")
+		}
+		b.WriteString(c.expr[c.i : c.i+end])
+		c.i += end + 1
+		if !c.consume(quote) {
+			return b.String(), nil
+		}
+		b.WriteString(quote)
+	}
+}
+
+// nameTest parses a name test: *, a QName, prefix:*, *:local, or an
+// expanded name written as {uri}local or Q{uri}local
+func (c *compiler) nameTest(isElementName bool) (n nameTest, err error) {
+	if c.consume("{") || c.consume("Q{") {
+		end := strings.IndexByte(c.expr[c.i:], '}')
+		if end < 0 {
+			return n, c.errorf("expected }")
+		}
+		n.space = c.expr[c.i : c.i+end]
+		c.i += end + 1
+		if n.local = c.ncName(); n.local == "" {
+			return n, c.errorf("expected local name")
+		}
+		return
+	}
+
+	if c.consume("*") {
+		n.anySpace = true
+		if c.consume(":") {
+			if n.local = c.ncName(); n.local == "" {
+				return n, c.errorf("expected local name")
+			}
+		}
+		return
+	}
+
+	prefix := c.ncName()
+	if prefix == "" {
+		return n, c.errorf("expected name")
+	}
+	if !c.consume(":") {
+		n.local = prefix
+		if isElementName {
+			n.space = c.ns[""]
+		}
+		return
+	}
+
+	if prefix == xmlPrefix {
+		n.space = xmlSpace
+	} else if uri, ok := c.ns[prefix]; ok {
+		n.space = uri
+	} else {
+		return n, c.errorf("unmapped namespace prefix: %s", prefix)
+	}
+	if !c.consume("*") {
+		if n.local = c.ncName(); n.local == "" {
+			return n, c.errorf("expected local name")
+		}
+	}
+	return
+}
+
+// ncName parses a name without a colon
+func (c *compiler) ncName() string {
+	start := c.i
+	for c.i < len(c.expr) {
+		r, size := utf8.DecodeRuneInString(c.expr[c.i:])
+		if !isNameChar(r) {
+			break
+		}
+		c.i += size
+	}
+	return c.expr[start:c.i]
+}
+
+func isNameChar(r rune) bool {
+	return r >= utf8.RuneSelf && r != utf8.RuneError ||
+		'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' ||
+		r == '_' || r == '-' || r == '.'
+}
+
+// Match tracks the evaluation of a Pattern against the elements
+// pushed onto an XmlPath.  It is created by XmlPath.Watch, and is
+// updated by each Push and Pop.
+type Match struct {
+	p      *Pattern
+	states []uint64 // for each open element, the set of steps matched
+	inside []bool   // for each open element, whether it or an ancestor matched
+	counts [][]int  // for each open element, the counters of position predicates
+}
+
+func newMatch(p *Pattern) *Match {
+	m := &Match{p: p}
+	m.states = append(m.states, 1)
+	m.inside = append(m.inside, false)
+	m.counts = append(m.counts, m.counters())
+	return m
+}
+
+func (m *Match) counters() []int {
+	if m.p.positions == 0 {
+		return nil
+	}
+	return make([]int, m.p.positions)
+}
+
+// Pattern returns the pattern being evaluated
+func (m *Match) Pattern() *Pattern {
+	return m.p
+}
+
+// Matched reports whether the current element matches the pattern
+func (m *Match) Matched() bool {
+	return m.states[len(m.states)-1]&(1<<uint(len(m.p.steps))) != 0
+}
+
+// Inside reports whether the current element, or one of its
+// ancestors, matches the pattern
+func (m *Match) Inside() bool {
+	return m.inside[len(m.inside)-1]
+}
+
+// push evaluates the pattern against a child of the current element
+func (m *Match) push(name xml.Name, attr []xml.Attr) {
+	n := len(m.states) - 1
+	parent, counts := m.states[n], m.counts[n]
+
+	var state uint64
+	for k := range m.p.steps {
+		s := &m.p.steps[k]
+		if s.descendant && parent&(1<<uint(k)) != 0 {
+			state |= 1 << uint(k)
+		}
+		// a step is tested even when it is not reached, so that
+		// the counters of its position predicates stay accurate
+		if s.match(name, attr, counts) && parent&(1<<uint(k)) != 0 {
+			state |= 1 << uint(k+1)
+		}
+	}
+
+	m.states = append(m.states, state)
+	m.inside = append(m.inside, m.inside[n] || state&(1<<uint(len(m.p.steps))) != 0)
+	m.counts = append(m.counts, m.counters())
+}
+
+func (m *Match) pop() {
+	if n := len(m.states) - 1; n > 0 {
+		m.states = m.states[:n]
+		m.inside = m.inside[:n]
+		m.counts = m.counts[:n]
+	}
+}
+
+// match reports whether an element passes the name test and the
+// predicates of s, counting it among the siblings of the position
+// predicates it reaches
+func (s *step) match(name xml.Name, attr []xml.Attr, counts []int) bool {
+	if !s.name.match(name) {
+		return false
+	}
+	for _, pred := range s.preds {
+		if pred.position > 0 {
+			counts[pred.counter]++
+			if counts[pred.counter] != pred.position {
+				return false
+			}
+			continue
+		}
+		found := false
+		for _, a := range attr {
+			if pred.attr.match(a.Name) && (!pred.hasValue || a.Value == pred.value) && !isXmlns(a.Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// isXmlns reports whether name is that of a namespace declaration
+func isXmlns(name xml.Name) bool {
+	return name.Space == xmlnsPrefix || (name.Space == "" && name.Local == xmlnsPrefix)
+}
+
+const xmlnsPrefix = "xmlns"
@@ -2,21 +2,30 @@ package xmlpath
 
 import (
 	"encoding/xml"
-	"fmt"
-	"path"
 
 	"github.com/jimrobinson/xml/xmlns"
 )
 
 type XmlPath struct {
-	ns   *xmlns.XmlNamespace
-	path []string
+	ns      *xmlns.XmlNamespace
+	levels  []level            // each element in the path
+	counts  []map[xml.Name]int // children of the document and of each element, by name
+	matches []*Match           // patterns being evaluated
+}
+
+// level records an element of the path
+type level struct {
+	name     xml.Name       // expanded name
+	qname    string         // prefixed name, as in scope when pushed
+	attr     []xml.Attr     // attributes, with expanded names
+	scope    *xmlns.Mapping // namespaces in scope for the element
+	position int            // position among the preceding siblings of the same name
 }
 
 func NewXmlPath() *XmlPath {
 	return &XmlPath{
-		ns:   xmlns.NewXmlNamespace(),
-		path: make([]string, 0),
+		ns:     xmlns.NewXmlNamespace(),
+		counts: make([]map[xml.Name]int, 1),
 	}
 }
 
@@ -26,34 +35,138 @@ func (xp *XmlPath) Push(node xml.StartElement) {
 
 func (xp *XmlPath) PushNS(node xml.StartElement, ns []xml.Name) {
 	xp.ns.PushNS(node, ns)
+	xp.push(node.Name, xp.qname(node.Name), append([]xml.Attr(nil), node.Attr...))
+}
+
+// PushRaw is like Push, for a node reported by xml.Decoder.RawToken,
+// whose name carries its prefix as written rather than a namespace
+// uri.
+func (xp *XmlPath) PushRaw(node xml.StartElement) {
+	xp.ns.Push(node)
 
-	var name string
-	if prefix := xp.ns.Prefix(node.Name.Space); prefix != "" {
-		name = fmt.Sprintf("%s:%s", prefix, node.Name.Local)
-	} else {
-		name = node.Name.Local
+	qname := node.Name.Local
+	if node.Name.Space != "" {
+		qname = node.Name.Space + ":" + qname
 	}
 
-	xp.path = append(xp.path, name)
+	var attr []xml.Attr
+	if len(node.Attr) > 0 {
+		attr = make([]xml.Attr, len(node.Attr))
+		for i, a := range node.Attr {
+			attr[i] = xml.Attr{Name: xp.ns.Translate(a.Name, false), Value: a.Value}
+		}
+	}
+	xp.push(xp.ns.Translate(node.Name, true), qname, attr)
+}
+
+// push records an element, whose namespaces have been pushed, and
+// evaluates the patterns being watched.  attr is not copied.
+func (xp *XmlPath) push(name xml.Name, qname string, attr []xml.Attr) {
+	n := len(xp.counts) - 1
+	if xp.counts[n] == nil {
+		xp.counts[n] = make(map[xml.Name]int)
+	}
+	xp.counts[n][name]++
+	xp.counts = append(xp.counts, nil)
+	xp.levels = append(xp.levels, level{name: name, qname: qname, attr: attr, scope: xp.ns.InScope(), position: xp.counts[n][name]})
+	for _, m := range xp.matches {
+		m.push(name, attr)
+	}
 }
 
 func (xp *XmlPath) Pop() {
-	if len(xp.path) == 0 {
+	if len(xp.levels) == 0 {
 		return
 	}
 	xp.ns.Pop()
-	xp.path = xp.path[0 : len(xp.path)-1]
+	xp.levels = xp.levels[0 : len(xp.levels)-1]
+	xp.counts = xp.counts[0 : len(xp.counts)-1]
+	for _, m := range xp.matches {
+		m.pop()
+	}
+}
+
+// Watch starts the evaluation of p against the current element and
+// each element pushed after it, returning the Match that reports
+// whether they match.  Watch is normally called before the first
+// Push: the elements already in the path are evaluated in turn, but
+// the siblings that preceded them are not known, so position
+// predicates count only the siblings pushed after Watch.
+func (xp *XmlPath) Watch(p *Pattern) *Match {
+	m := newMatch(p)
+	for _, l := range xp.levels {
+		m.push(l.name, l.attr)
+	}
+	xp.matches = append(xp.matches, m)
+	return m
 }
 
 func (xp *XmlPath) Peek() string {
-	if len(xp.path) == 0 {
+	if len(xp.levels) == 0 {
 		return "/"
 	}
-	return xp.path[len(xp.path)-1]
+	return xp.levels[len(xp.levels)-1].qname
+}
+
+// Depth returns the number of elements in the path
+func (xp *XmlPath) Depth() int {
+	return len(xp.levels)
+}
+
+// Namespaces returns the namespace mappings tracked by the path
+func (xp *XmlPath) Namespaces() *xmlns.XmlNamespace {
+	return xp.ns
+}
+
+// Name returns the expanded name of the current element
+func (xp *XmlPath) Name() xml.Name {
+	if len(xp.levels) == 0 {
+		return xml.Name{}
+	}
+	return xp.levels[len(xp.levels)-1].name
+}
+
+// Names returns the expanded names of the elements in the path
+func (xp *XmlPath) Names() []xml.Name {
+	names := make([]xml.Name, len(xp.levels))
+	for i, l := range xp.levels {
+		names[i] = l.name
+	}
+	return names
+}
+
+// Position returns the position of the current element among its
+// siblings of the same name, starting at 1, or 0 if the path is
+// empty.
+func (xp *XmlPath) Position() int {
+	if len(xp.levels) == 0 {
+		return 0
+	}
+	return xp.levels[len(xp.levels)-1].position
+}
+
+// Attr returns the value of the attribute of the current element
+// named name, in which the namespace is given as a uri.
+func (xp *XmlPath) Attr(name xml.Name) (value string, ok bool) {
+	if len(xp.levels) == 0 {
+		return "", false
+	}
+	return lookup(xp.levels[len(xp.levels)-1].attr, name)
+}
+
+func lookup(attr []xml.Attr, name xml.Name) (string, bool) {
+	for _, a := range attr {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
 }
 
+// String renders the path with the prefixes in scope when each
+// element was pushed.  See Format.
 func (xp *XmlPath) String() string {
-	return fmt.Sprintf("/%s", path.Join(xp.path...))
+	return xp.Format(Format{})
 }
 
 func (xp *XmlPath) XmlnsCheck(node xml.StartElement) error {
@@ -0,0 +1,306 @@
+package xmlpath
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+const atomSpace = "http://www.w3.org/2005/Atom"
+const xhtmlSpace = "http://www.w3.org/1999/xhtml"
+
+var testNamespaces = map[string]string{
+	"atom":  atomSpace,
+	"xhtml": xhtmlSpace,
+}
+
+const testFeed = `<feed xmlns="http://www.w3.org/2005/Atom" xmlns:h="http://www.w3.org/1999/xhtml">
+	<title>t</title>
+	<entry id="a"><title>1</title><link rel="self"/><link rel="alternate"/></entry>
+	<entry id="b"><title>2</title><content><h:div><h:p><h:img/></h:p></h:div></content></entry>
+	<entry><title>3</title><content type="text"/></entry>
+	<other xmlns=""><title/></other>
+</feed>`
+
+type patternTest struct {
+	pattern string
+	ns      map[string]string
+	matched []string // paths of the elements matched
+	inside  int      // number of elements inside a match
+}
+
+var patternTests = []patternTest{
+	{"/atom:feed/atom:entry/atom:content", testNamespaces, []string{"/feed/entry/content", "/feed/entry/content"}, 5},
+	{"/feed/entry/content", map[string]string{"": atomSpace}, []string{"/feed/entry/content", "/feed/entry/content"}, 5},
+	{"/feed/entry/content", nil, nil, 0},
+	{"//xhtml:img", testNamespaces, []string{"/feed/entry/content/h:div/h:p/h:img"}, 1},
+	{"atom:entry//xhtml:*", testNamespaces, []string{"/feed/entry/content/h:div", "/feed/entry/content/h:div/h:p", "/feed/entry/content/h:div/h:p/h:img"}, 3},
+	{"*:title", nil, []string{"/feed/title", "/feed/entry/title", "/feed/entry/title", "/feed/entry/title", "/feed/other/title"}, 5},
+	{"/*/title", nil, nil, 0},
+	{"/*/*/title", nil, []string{"/feed/other/title"}, 1},
+	{"{http://www.w3.org/2005/Atom}entry[@id]", nil, []string{"/feed/entry", "/feed/entry"}, 10},
+	{"atom:entry[@id='b']/atom:title", testNamespaces, []string{"/feed/entry/title"}, 1},
+	{"atom:link[@rel='alternate']", testNamespaces, []string{"/feed/entry/link"}, 1},
+	{"atom:entry[3]/atom:title", testNamespaces, []string{"/feed/entry/title"}, 1},
+	{"atom:entry[@id][2]", testNamespaces, []string{"/feed/entry"}, 6},
+	{"atom:feed/*[2]", testNamespaces, []string{"/feed/entry"}, 4},
+	{"atom:content[@*]", testNamespaces, []string{"/feed/entry/content"}, 1},
+	{"/atom:feed//atom:title[1]", testNamespaces, []string{"/feed/title", "/feed/entry/title", "/feed/entry/title", "/feed/entry/title"}, 4},
+}
+
+// walk pushes and pops the elements of input, reporting the path of
+// each element matched by p and the number of elements inside a match
+func walk(t *testing.T, input string, p *Pattern, raw bool) (matched []string, inside int) {
+	xp := NewXmlPath()
+	m := xp.Watch(p)
+	dec := xml.NewDecoder(strings.NewReader(input))
+	for {
+		var tok xml.Token
+		var err error
+		if raw {
+			tok, err = dec.RawToken()
+		} else {
+			tok, err = dec.Token()
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		switch node := tok.(type) {
+		case xml.StartElement:
+			if raw {
+				xp.PushRaw(node)
+			} else {
+				xp.Push(node)
+			}
+			if m.Matched() {
+				matched = append(matched, xp.String())
+			}
+			if m.Inside() {
+				inside++
+			}
+		case xml.EndElement:
+			xp.Pop()
+		}
+	}
+	return
+}
+
+func TestPattern(t *testing.T) {
+	for _, v := range patternTests {
+		p, err := Compile(v.pattern, v.ns)
+		if err != nil {
+			t.Errorf("%s: %v", v.pattern, err)
+			continue
+		}
+		for _, raw := range []bool{false, true} {
+			matched, inside := walk(t, testFeed, p, raw)
+			if strings.Join(matched, " ") != strings.Join(v.matched, " ") {
+				t.Errorf("%s (raw %v): expected %v, got %v", v.pattern, raw, v.matched, matched)
+			}
+			if inside != v.inside {
+				t.Errorf("%s (raw %v): expected %d elements inside, got %d", v.pattern, raw, v.inside, inside)
+			}
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"/",
+		"a/",
+		"a//",
+		"x:a",
+		"a[",
+		"a[0]",
+		"a[@b=c]",
+		"a[@b='c]",
+		"{urn:x",
+		"a b",
+		strings.Repeat("/a", maxSteps+1),
+	} {
+		if _, err := Compile(expr, nil); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestWatchAfterPush(t *testing.T) {
+	xp := NewXmlPath()
+	xp.Push(xml.StartElement{Name: xml.Name{Local: "a"}})
+	m := xp.Watch(MustCompile("/a", nil))
+	if !m.Matched() || !m.Inside() {
+		t.Errorf("expected /a to match")
+	}
+	xp.Push(xml.StartElement{Name: xml.Name{Local: "b"}})
+	if m.Matched() || !m.Inside() {
+		t.Errorf("expected /a/b to be inside a match")
+	}
+	xp.Pop()
+	xp.Pop()
+	if m.Matched() || m.Inside() {
+		t.Errorf("expected no match at the root")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	input := `<feed xmlns:x="urn:x"><title/><entry id="a"><title/></entry><entry x:id="b"/><entry><title/><title/></entry></feed>`
+	formats := []Format{
+		{},
+		{Positions: true},
+		{Attrs: []xml.Name{{Local: "id"}, {Space: "urn:x", Local: "id"}}},
+		{Positions: true, Attrs: []xml.Name{{Local: "id"}}},
+	}
+	expected := [][]string{
+		{"/feed", "/feed/title", "/feed/entry", "/feed/entry/title", "/feed/entry", "/feed/entry", "/feed/entry/title", "/feed/entry/title"},
+		{"/feed[1]", "/feed[1]/title[1]", "/feed[1]/entry[1]", "/feed[1]/entry[1]/title[1]", "/feed[1]/entry[2]", "/feed[1]/entry[3]", "/feed[1]/entry[3]/title[1]", "/feed[1]/entry[3]/title[2]"},
+		{"/feed", "/feed/title", "/feed/entry[@id='a']", "/feed/entry[@id='a']/title", "/feed/entry[@x:id='b']", "/feed/entry", "/feed/entry/title", "/feed/entry/title"},
+		{"/feed[1]", "/feed[1]/title[1]", "/feed[1]/entry[@id='a']", "/feed[1]/entry[@id='a']/title[1]", "/feed[1]/entry[2]", "/feed[1]/entry[3]", "/feed[1]/entry[3]/title[1]", "/feed[1]/entry[3]/title[2]"},
+	}
+
+	for i, f := range formats {
+		xp := NewXmlPath()
+		dec := xml.NewDecoder(strings.NewReader(input))
+		var paths []string
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			switch node := tok.(type) {
+			case xml.StartElement:
+				xp.Push(node)
+				paths = append(paths, xp.Format(f))
+			case xml.EndElement:
+				xp.Pop()
+			}
+		}
+		if strings.Join(paths, " ") != strings.Join(expected[i], " ") {
+			t.Errorf("format %d: expected\n%v\ngot\n%v", i, expected[i], paths)
+		}
+		if s := xp.Format(f); s != "/" {
+			t.Errorf("format %d: expected / for an empty path, got %s", i, s)
+		}
+	}
+}
+
+func TestFormatAttrScope(t *testing.T) {
+	// the attribute of a is rendered with the prefix in scope for a
+	input := `<a xmlns:x="urn:x" x:id="1"><b xmlns:y="urn:x"/></a>`
+	f := Format{Attrs: []xml.Name{{Space: "urn:x", Local: "id"}}}
+	xp := NewXmlPath()
+	dec := xml.NewDecoder(strings.NewReader(input))
+	for xp.Depth() < 2 {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node, ok := tok.(xml.StartElement); ok {
+			xp.Push(node)
+		}
+	}
+	if s := xp.Format(f); s != "/a[@x:id='1']/b" {
+		t.Errorf("expected /a[@x:id='1']/b, got %s", s)
+	}
+}
+
+func TestPushCopiesAttr(t *testing.T) {
+	node := xml.StartElement{Name: xml.Name{Local: "a"}, Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "1"}}}
+	xp := NewXmlPath()
+	xp.Push(node)
+	node.Attr[0].Value = "2"
+	if value, _ := xp.Attr(xml.Name{Local: "id"}); value != "1" {
+		t.Errorf("expected id 1, got %s", value)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, v := range []struct{ value, literal string }{
+		{"a", "'a'"},
+		{"it's", `"it's"`},
+		{`it's "x"`, `'it''s "x"'`},
+		{`''`, `"''"`},
+	} {
+		if s := quote(v.value); s != v.literal {
+			t.Errorf("%s: expected %s, got %s", v.value, v.literal, s)
+		}
+
+		// the literal compiles back to the value
+		p, err := Compile("a[@b="+v.literal+"]", nil)
+		if err != nil {
+			t.Errorf("%s: %v", v.literal, err)
+			continue
+		}
+		xp := NewXmlPath()
+		m := xp.Watch(p)
+		xp.Push(xml.StartElement{Name: xml.Name{Local: "a"}, Attr: []xml.Attr{{Name: xml.Name{Local: "b"}, Value: v.value}}})
+		if !m.Matched() {
+			t.Errorf("%s: expected a match for %s", v.literal, v.value)
+		}
+	}
+}
+
+func TestNameStyles(t *testing.T) {
+	const custom = "urn:custom"
+	input := `<feed xmlns="http://www.w3.org/2005/Atom"><entry><content><div xmlns="http://www.w3.org/1999/xhtml"><x:p xmlns:x="urn:custom" xml:lang="en"/></div></content></entry></feed>`
+	lang := xml.Name{Space: xmlSpace, Local: "lang"}
+
+	tests := []struct {
+		format Format
+		path   string
+	}{
+		{Format{}, "/feed/entry/content/div/x:p"},
+		{Format{Attrs: []xml.Name{lang}}, "/feed/entry/content/div/x:p[@xml:lang='en']"},
+		{Format{Names: Clark}, "/{" + atomSpace + "}feed/{" + atomSpace + "}entry/{" + atomSpace + "}content/{" + xhtmlSpace + "}div/{urn:custom}p"},
+		{Format{Names: Clark, Attrs: []xml.Name{lang}}, "/{" + atomSpace + "}feed/{" + atomSpace + "}entry/{" + atomSpace + "}content/{" + xhtmlSpace + "}div/{urn:custom}p[@{" + xmlSpace + "}lang='en']"},
+		{Format{Names: EQName, Positions: true}, "/Q{" + atomSpace + "}feed[1]/Q{" + atomSpace + "}entry[1]/Q{" + atomSpace + "}content[1]/Q{" + xhtmlSpace + "}div[1]/Q{urn:custom}p[1]"},
+		{Format{Prefixes: map[string]string{"atom": atomSpace, "h": xhtmlSpace}}, "/atom:feed/atom:entry/atom:content/h:div/Q{urn:custom}p"},
+		{Format{Prefixes: map[string]string{"": atomSpace, "h": xhtmlSpace, "html": xhtmlSpace, "c": custom}, Attrs: []xml.Name{lang}}, "/feed/entry/content/h:div/c:p[@xml:lang='en']"},
+	}
+
+	for _, raw := range []bool{false, true} {
+		xp := NewXmlPath()
+		dec := xml.NewDecoder(strings.NewReader(input))
+		for xp.Peek() != "x:p" {
+			var tok xml.Token
+			var err error
+			if raw {
+				tok, err = dec.RawToken()
+			} else {
+				tok, err = dec.Token()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if node, ok := tok.(xml.StartElement); ok && raw {
+				xp.PushRaw(node)
+			} else if ok {
+				xp.Push(node)
+			}
+		}
+
+		for _, v := range tests {
+			if s := xp.Format(v.format); s != v.path {
+				t.Errorf("raw %v: expected %s, got %s", raw, v.path, s)
+			}
+		}
+		if name := xp.Name(); name != (xml.Name{Space: custom, Local: "p"}) {
+			t.Errorf("raw %v: unexpected name %v", raw, name)
+		}
+	}
+}
+
+func TestUnmappedNamespace(t *testing.T) {
+	xp := NewXmlPath()
+	xp.Push(xml.StartElement{Name: xml.Name{Space: "urn:a", Local: "a"}, Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: "urn:a"}}})
+	xp.Push(xml.StartElement{Name: xml.Name{Space: "urn:b", Local: "b"}})
+	xp.Push(xml.StartElement{Name: xml.Name{Local: "c"}})
+	if expected := "/a/Q{urn:b}b/c"; xp.String() != expected {
+		t.Errorf("expected %s, got %s", expected, xp.String())
+	}
+}